@@ -0,0 +1,64 @@
+//go:build windows || (cgo && (darwin || linux || openbsd))
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.design/x/hotkey"
+)
+
+// hotkeyKeys 快捷键绑定中可用的主键名（小写）
+var hotkeyKeys = map[string]hotkey.Key{
+	"a": hotkey.KeyA, "b": hotkey.KeyB, "c": hotkey.KeyC, "d": hotkey.KeyD,
+	"e": hotkey.KeyE, "f": hotkey.KeyF, "g": hotkey.KeyG, "h": hotkey.KeyH,
+	"i": hotkey.KeyI, "j": hotkey.KeyJ, "k": hotkey.KeyK, "l": hotkey.KeyL,
+	"m": hotkey.KeyM, "n": hotkey.KeyN, "o": hotkey.KeyO, "p": hotkey.KeyP,
+	"q": hotkey.KeyQ, "r": hotkey.KeyR, "s": hotkey.KeyS, "t": hotkey.KeyT,
+	"u": hotkey.KeyU, "v": hotkey.KeyV, "w": hotkey.KeyW, "x": hotkey.KeyX,
+	"y": hotkey.KeyY, "z": hotkey.KeyZ,
+	"0": hotkey.Key0, "1": hotkey.Key1, "2": hotkey.Key2, "3": hotkey.Key3,
+	"4": hotkey.Key4, "5": hotkey.Key5, "6": hotkey.Key6, "7": hotkey.Key7,
+	"8": hotkey.Key8, "9": hotkey.Key9,
+	"space": hotkey.KeySpace, "return": hotkey.KeyReturn,
+	"f1": hotkey.KeyF1, "f2": hotkey.KeyF2, "f3": hotkey.KeyF3, "f4": hotkey.KeyF4,
+	"f5": hotkey.KeyF5, "f6": hotkey.KeyF6, "f7": hotkey.KeyF7, "f8": hotkey.KeyF8,
+	"f9": hotkey.KeyF9, "f10": hotkey.KeyF10, "f11": hotkey.KeyF11, "f12": hotkey.KeyF12,
+}
+
+// newHotkey 解析形如 "ctrl+shift+t" 的绑定，最后一段为主键，其余为修饰键
+func newHotkey(binding string) (*hotkey.Hotkey, error) {
+	parts := strings.Split(strings.ToLower(strings.ReplaceAll(binding, " ", "")), "+")
+	key, ok := hotkeyKeys[parts[len(parts)-1]]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q in hotkey %q", parts[len(parts)-1], binding)
+	}
+	var mods []hotkey.Modifier
+	for _, p := range parts[:len(parts)-1] {
+		mod, ok := hotkeyModifiers[p]
+		if !ok {
+			return nil, fmt.Errorf("unknown modifier %q in hotkey %q", p, binding)
+		}
+		mods = append(mods, mod)
+	}
+	return hotkey.New(mods, key), nil
+}
+
+// startHotkey 注册全局快捷键 binding，每次按下时在独立的 goroutine 中调用 onPress，返回注销用的 stop
+func startHotkey(binding string, onPress func()) (stop func(), err error) {
+	hk, err := newHotkey(binding)
+	if err != nil {
+		return nil, err
+	}
+	if err := hk.Register(); err != nil {
+		return nil, err
+	}
+	go func() {
+		// Unregister 会关闭事件通道，从而结束本 goroutine
+		for range hk.Keydown() {
+			onPress()
+		}
+	}()
+	return func() { hk.Unregister() }, nil
+}
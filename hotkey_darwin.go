@@ -0,0 +1,13 @@
+//go:build darwin && cgo
+
+package main
+
+import "golang.design/x/hotkey"
+
+// hotkeyModifiers 修饰键名到 macOS 修饰键的映射，alt 对应 Option，super 对应 Command
+var hotkeyModifiers = map[string]hotkey.Modifier{
+	"ctrl":  hotkey.ModCtrl,
+	"shift": hotkey.ModShift,
+	"alt":   hotkey.ModOption,
+	"super": hotkey.ModCmd,
+}
@@ -0,0 +1,10 @@
+//go:build !windows && !(cgo && (darwin || linux || openbsd))
+
+package main
+
+import "errors"
+
+// startHotkey 在未启用 cgo 或不支持全局快捷键的平台上不可用，调用方记录警告后继续运行
+func startHotkey(binding string, onPress func()) (stop func(), err error) {
+	return nil, errors.New("global hotkeys are unavailable in this build (requires cgo on darwin, linux or openbsd)")
+}
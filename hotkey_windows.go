@@ -0,0 +1,13 @@
+//go:build windows
+
+package main
+
+import "golang.design/x/hotkey"
+
+// hotkeyModifiers 修饰键名到 Windows 修饰键的映射
+var hotkeyModifiers = map[string]hotkey.Modifier{
+	"ctrl":  hotkey.ModCtrl,
+	"shift": hotkey.ModShift,
+	"alt":   hotkey.ModAlt,
+	"super": hotkey.ModWin,
+}
@@ -0,0 +1,13 @@
+//go:build (linux || openbsd) && cgo
+
+package main
+
+import "golang.design/x/hotkey"
+
+// hotkeyModifiers 修饰键名到 X11 修饰键的映射，Mod1 通常为 Alt，Mod4 通常为 Super
+var hotkeyModifiers = map[string]hotkey.Modifier{
+	"ctrl":  hotkey.ModCtrl,
+	"shift": hotkey.ModShift,
+	"alt":   hotkey.Mod1,
+	"super": hotkey.Mod4,
+}
@@ -0,0 +1,60 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"syscall"
+)
+
+// unixSocketIPC 使用 /tmp 下的 Unix socket 文件进行单实例通信
+type unixSocketIPC struct {
+	path string
+}
+
+// newInstanceIPC 返回当前平台的通信端点
+// socket 路径的优先级：环境变量 MYTODO_SOCKET > /tmp/todo-app-<用户名>.sock > /tmp/todo-app.sock
+// 为了简单和权限问题，默认放在 /tmp 下，并加上用户名以避免冲突
+func newInstanceIPC() instanceIPC {
+	if p := os.Getenv("MYTODO_SOCKET"); p != "" {
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			log.Printf("Warning: cannot create directory for MYTODO_SOCKET %q: %v", p, err)
+		}
+		return unixSocketIPC{path: p}
+	}
+	currentUser, err := user.Current()
+	if err != nil {
+		// 如果获取用户失败，使用一个通用名称
+		return unixSocketIPC{path: filepath.Join("/tmp", socketFileName)}
+	}
+	return unixSocketIPC{path: filepath.Join("/tmp", fmt.Sprintf("todo-app-%s.sock", currentUser.Username))}
+}
+
+func (u unixSocketIPC) Dial() (net.Conn, error) {
+	return net.DialTimeout("unix", u.path, ipcTimeout)
+}
+
+// Listen 不会删除已存在的 socket 文件，残留文件由 isStaleEndpoint 判断后清理
+func (u unixSocketIPC) Listen() (net.Listener, error) {
+	return net.Listen("unix", u.path)
+}
+
+func (u unixSocketIPC) Cleanup() {
+	_ = os.Remove(u.path)
+}
+
+func (u unixSocketIPC) Address() string {
+	return u.path
+}
+
+// isStaleEndpoint 判断 Dial 的错误是否表示 socket 文件残留：
+// 文件存在但没有进程在监听时，连接会被拒绝
+func isStaleEndpoint(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
@@ -0,0 +1,57 @@
+//go:build windows
+
+package main
+
+import (
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/Microsoft/go-winio"
+)
+
+// namedPipeIPC 使用 Windows 命名管道进行单实例通信
+type namedPipeIPC struct {
+	name string
+}
+
+// newInstanceIPC 返回当前平台的通信端点
+// 管道名的优先级：环境变量 MYTODO_SOCKET（可省略 \\.\pipe\ 前缀）> todo-app-<用户名> > todo-app
+// 管道名中加上用户名以避免不同用户之间冲突
+func newInstanceIPC() instanceIPC {
+	if name := os.Getenv("MYTODO_SOCKET"); name != "" {
+		if !strings.HasPrefix(name, `\\.\pipe\`) {
+			name = `\\.\pipe\` + name
+		}
+		return namedPipeIPC{name: name}
+	}
+	name := strings.TrimSuffix(socketFileName, filepath.Ext(socketFileName))
+	if currentUser, err := user.Current(); err == nil {
+		// Windows 用户名形如 DOMAIN\user，反斜杠不能出现在管道名中
+		name += "-" + strings.ReplaceAll(currentUser.Username, `\`, "-")
+	}
+	return namedPipeIPC{name: `\\.\pipe\` + name}
+}
+
+func (p namedPipeIPC) Dial() (net.Conn, error) {
+	timeout := ipcTimeout
+	return winio.DialPipe(p.name, &timeout)
+}
+
+func (p namedPipeIPC) Listen() (net.Listener, error) {
+	return winio.ListenPipe(p.name, nil)
+}
+
+// Cleanup 命名管道随进程退出自动释放，无需清理
+func (p namedPipeIPC) Cleanup() {}
+
+func (p namedPipeIPC) Address() string {
+	return p.name
+}
+
+// isStaleEndpoint 命名管道不会在进程退出后残留
+func isStaleEndpoint(err error) bool {
+	return false
+}
@@ -2,423 +2,4431 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	_ "embed"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
+	_ "image/jpeg"
 	"image/png"
+	"io"
 	"log"
+	"maps"
+	"mime"
 	"net"
+	"net/http"
 	"os"
-	"os/user"
+	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 	"unicode"
+	"unicode/utf8"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/driver/desktop"
 	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
+	"github.com/fsnotify/fsnotify"
+	"github.com/rivo/uniseg"
+	"golang.org/x/crypto/scrypt"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
 )
 
+// version 为程序版本，发布构建时通过 -ldflags "-X main.version=..." 设置
+var version = "dev"
+
 const (
 	// appID 用于系统识别，保持不变
 	appID = "io.github.dylan.todo.tray"
 	// Socket 文件名，用于单实例检测
 	socketFileName = "todo-app.sock"
+	// ipcTimeout 为连接已有实例以及收发消息的最长等待时间，超时视为该实例不可用
+	ipcTimeout = 2 * time.Second
+
+	maxWeight     = 40 // 输入默认上限：20中 / 40英，可由 max_weight 配置
+	maxShowWeight = 40 // 托盘显示默认上限：20中 / 40英，可由 max_show_weight 配置
 
-	maxWeight     = 40 // 输入：20中 / 40英
-	maxShowWeight = 40 // 托盘显示：10中 / 20英
+	hookTimeout = 10 * time.Second // 单个钩子命令的最长运行时间
+
+	// focusPrefix 输入框中以此开头的内容设置为当前专注，而不是新增待办
+	focusPrefix = "专注:"
+	// filterPrefix 输入框中以此开头的内容作为托盘筛选关键字，边输入边筛选
+	filterPrefix = "/"
+	// listPrefix 输入框中以此开头的内容切换到（或新建）同名列表
+	listPrefix = "列表:"
 )
 
 // 全局变量，用于存储路径
 var (
-	// configDir 存储可执行文件所在的目录
+	// dataDir 存储待办数据的目录（$XDG_DATA_HOME/mytodo）
+	dataDir string
+	// configDir 存储配置文件的目录（$XDG_CONFIG_HOME/mytodo）
 	configDir string
-	// dataFile 存储 todo.json 的完整路径
+	// cacheDir 存储可再生文件（如托盘图标）的目录（$XDG_CACHE_HOME/mytodo）
+	cacheDir string
+	// dataFile 存储启动时所选列表数据文件的完整路径：默认列表为 todo.json，其他列表为 todo-<名称>.json
+	// 运行中切换列表后以 fileStore.Path 为准
 	dataFile string
+	// dataFileEnv 为环境变量 MYTODO_DATA 指定的默认列表数据文件，为空表示未设置
+	dataFileEnv string
+	// activeList 为当前列表的名称，默认列表为空；只在主 goroutine 中修改
+	activeList string
+	// archiveFile 存储当前列表已归档待办的完整路径：默认列表为 done.json，其他列表为 done-<名称>.json
+	archiveFile string
+	// trashFile 存储当前列表回收站的完整路径：默认列表为 trash.json，其他列表为 trash-<名称>.json
+	trashFile string
 	// iconFile 存储 tray.png 的完整路径
 	iconFile string
-	// socketPath 存储 socket 文件的完整路径
-	socketPath string
+	// ipc 是单实例通信端点：Unix 上为 socket 文件，Windows 上为命名管道
+	ipc instanceIPC
+	// configFile 存储 config.json 的完整路径
+	configFile string
+	// windowFile 存储输入窗口尺寸 window.json 的完整路径
+	windowFile string
+	// draftFile 存储输入框未提交内容 draft.txt 的完整路径
+	draftFile string
+	// statsFile 存储当前列表每日完成数量的完整路径：默认列表为 stats.json，其他列表为 stats-<名称>.json
+	statsFile string
 )
 
-type Todo struct {
-	Text string `json:"text"`
+// config 是启动时加载的用户配置
+var config Config
+
+// store 保存当前的待办列表，主实例在开始处理 socket 命令之前初始化
+var store Store
+
+// deletedTodo 记录被删除的待办及其原来的位置，用于撤销
+type deletedTodo struct {
+	todo  Todo
+	index int
 }
 
-/* ================= 工具函数 ================= */
+// trayFilter 是托盘菜单的筛选关键字，为空时显示全部待办
+var trayFilter string
 
-// 计算混合权重：中文2，其他1
-func getWeight(s string) int {
-	w := 0
-	for _, r := range s {
-		if unicode.Is(unicode.Han, r) {
-			w += 2
-		} else {
-			w += 1
+// matchesFilter 判断待办文本是否包含筛选关键字（不区分大小写）
+func matchesFilter(t Todo, filter string) bool {
+	return filter == "" || strings.Contains(strings.ToLower(t.Text), strings.ToLower(filter))
+}
+
+// fuzzyScore 计算 text 与 pattern 的模糊匹配分数（不区分大小写），不匹配时 ok 为 false
+// pattern 按空白拆分为若干词，各词不分先后，每个词的字符须按顺序出现在 text 中（子序列），
+// 中文等字符按单个 rune 比较；连续命中、在词首命中以及整词出现都会加分，字符间的间隔会扣分
+// 整词出现时从该处开始匹配，不会被更靠前的零散字符抢先
+func fuzzyScore(text, pattern string) (score int, ok bool) {
+	lower := strings.ToLower(text)
+	t := []rune(lower)
+	for _, word := range strings.Fields(strings.ToLower(pattern)) {
+		w := []rune(word)
+		from := 0
+		if idx := strings.Index(lower, word); idx >= 0 {
+			score += 2 * len(w)
+			from = utf8.RuneCountInString(lower[:idx])
+		}
+		last := -1
+		for _, r := range w {
+			i := max(last+1, from)
+			for i < len(t) && t[i] != r {
+				i++
+			}
+			if i == len(t) {
+				return 0, false
+			}
+			score++
+			switch {
+			case last >= 0 && i == last+1:
+				score += 5
+			case i == 0 || unicode.IsSpace(t[i-1]):
+				score += 8
+			}
+			if last >= 0 {
+				score -= min(i-last-1, 5)
+			}
+			last = i
 		}
 	}
-	return w
+	return score, true
 }
 
-// 根据权重截断字符串，如果被截断则添加省略号
-func truncateByWeightWithEllipsis(s string, maxW int) string {
-	if getWeight(s) <= maxW {
-		return s
+// filterOrder 从 order 中挑出符合筛选关键字的待办下标
+// 模糊筛选时按匹配分数从高到低排列，分数相同的保持 order 中的顺序
+func filterOrder(todos []Todo, order []int, filter string, fuzzy bool) []int {
+	if filter == "" {
+		return order
 	}
-
-	currW := 0
-	res := ""
-	for _, r := range s {
-		itemW := 1
-		if unicode.Is(unicode.Han, r) {
-			itemW = 2
+	var kept []int
+	scores := map[int]int{}
+	for _, i := range order {
+		if !fuzzy {
+			if matchesFilter(todos[i], filter) {
+				kept = append(kept, i)
+			}
+			continue
 		}
-		if currW+itemW > maxW {
-			break
+		if score, ok := fuzzyScore(todos[i].Text, filter); ok {
+			kept = append(kept, i)
+			scores[i] = score
 		}
-		currW += itemW
-		res += string(r)
 	}
-	return res + "…"
+	if fuzzy {
+		sort.SliceStable(kept, func(a, b int) bool { return scores[kept[a]] > scores[kept[b]] })
+	}
+	return kept
 }
 
-// 基础截断（不带省略号，用于输入框强制限制）
-func truncateByWeight(s string, maxW int) string {
-	currW := 0
-	res := ""
-	for _, r := range s {
-		itemW := 1
-		if unicode.Is(unicode.Han, r) {
-			itemW = 2
-		}
-		if currW+itemW > maxW {
-			break
+// trayHeader 返回托盘菜单顶部的布局：新增条目的文字、列表菜单之后是否加分隔线，以及没有待办时的占位文字
+// 紧凑模式下新增条目兼作标题行并显示数量 count，省略分隔线和占位条目
+func trayHeader(compact bool, count int) (addLabel string, separator bool, emptyLabel string) {
+	if compact {
+		return fmt.Sprintf("➕ 新增 · %d 待办", count), false, ""
+	}
+	return "➕ 新增待办", true, "（暂无待办）"
+}
+
+// trayGroups 为托盘菜单中待办的划分，元素均为 todos 的下标
+type trayGroups struct {
+	// direct 为直接列在菜单顶层的未打标签待办，数字键 1-9 依次对应其中的前 9 条
+	direct []int
+	// overflow 为超出 MaxTrayItems 后放入“更多…”子菜单的未打标签待办
+	overflow []int
+	// tagged 按标签分组，带多个标签的待办在每个标签下各出现一次
+	tagged map[string][]int
+}
+
+// groupForTray 按 order 的顺序划分托盘菜单中的待办，maxDirect 为 0 时不限制顶层条数
+func groupForTray(todos []Todo, order []int, maxDirect int) trayGroups {
+	g := trayGroups{tagged: map[string][]int{}}
+	for _, i := range order {
+		if len(todos[i].Tags) > 0 {
+			for _, tag := range todos[i].Tags {
+				g.tagged[tag] = append(g.tagged[tag], i)
+			}
+		} else if maxDirect > 0 && len(g.direct) >= maxDirect {
+			g.overflow = append(g.overflow, i)
+		} else {
+			g.direct = append(g.direct, i)
 		}
-		currW += itemW
-		res += string(r)
 	}
-	return res
+	return g
 }
 
-/* ================= 数据读写 ================= */
+// trash 是回收站中的待办，按删除时间从新到旧排列，只在主 goroutine 中访问
+var trash []trashedTodo
+
+// completions 是每天完成待办的数量，只在主 goroutine 中访问
+var completions completionLog
+
+// lastDeleted 是最近一次删除的待办，nil 表示没有可撤销的删除（只支持一级撤销）
+var lastDeleted *deletedTodo
+
+type Todo struct {
+	// ID 唯一标识，用于在文本重复时准确定位待办
+	ID   string `json:"id"`
+	Text string `json:"text"`
+	// Done 表示已完成；旧文件中没有该字段时默认为 false
+	Done bool `json:"done,omitempty"`
+	// DoneAt 完成时间，取消完成时清空
+	DoneAt *time.Time `json:"done_at,omitempty"`
+	// Due 截止时间，以 RFC3339 格式保存；nil 表示没有截止时间
+	Due *time.Time `json:"due,omitempty"`
+	// Priority 优先级：0 普通，1 高，2 紧急
+	Priority int `json:"priority,omitempty"`
+	// Created 创建时间，以 RFC3339 格式保存；旧文件中缺失时为零值
+	Created time.Time `json:"created"`
+	// Repeat 重复周期："daily" 或 "weekly"，为空表示不重复
+	Repeat string `json:"repeat,omitempty"`
+	// Tags 从文本中的 #标签 解析得到（不含 #），标签本身仍保留在 Text 中
+	Tags []string `json:"tags,omitempty"`
+	// Pinned 置顶：托盘中始终显示在未置顶的待办之前
+	Pinned bool `json:"pinned,omitempty"`
+	// Subtasks 子任务，结构与待办相同，可以继续嵌套；旧文件中没有该字段
+	Subtasks []Todo `json:"subtasks,omitempty"`
+}
+
+// 重复周期取值
+const (
+	repeatDaily  = "daily"
+	repeatWeekly = "weekly"
+)
+
+// repeatKeywords 输入中表示重复周期的关键词
+var repeatKeywords = map[string]string{"每天": repeatDaily, "每周": repeatWeekly}
 
-func loadTodos() []Todo {
-	if _, err := os.Stat(dataFile); os.IsNotExist(err) {
-		return []Todo{}
+// parseRepeat 识别文本中独立成词的 "每天"/"每周"，返回重复周期和去掉关键词后的文本
+func parseRepeat(text string) (string, string) {
+	fields := strings.Fields(text)
+	for i, f := range fields {
+		if repeat, ok := repeatKeywords[f]; ok {
+			return repeat, strings.Join(append(fields[:i:i], fields[i+1:]...), " ")
+		}
 	}
-	data, err := os.ReadFile(dataFile)
-	if err != nil {
-		log.Printf("Error reading todo file: %v", err)
-		return []Todo{}
+	return "", text
+}
+
+// nextDue 计算重复待办完成后的下一个截止时间：从原截止时间（没有则从 now）起
+// 按周期推进，直到晚于 now
+func nextDue(due *time.Time, repeat string, now time.Time) time.Time {
+	days := 1
+	if repeat == repeatWeekly {
+		days = 7
 	}
-	var todos []Todo
-	if err := json.Unmarshal(data, &todos); err != nil {
-		log.Printf("Error unmarshalling todo data: %v", err)
-		return []Todo{}
+	next := now
+	if due != nil {
+		next = *due
+	}
+	next = next.AddDate(0, 0, days)
+	for !next.After(now) {
+		next = next.AddDate(0, 0, days)
 	}
-	return todos
+	return next
 }
 
-func saveTodos(todos []Todo) {
-	data, err := json.MarshalIndent(todos, "", "  ")
-	if err != nil {
-		log.Printf("Error marshalling todo data: %v", err)
-		return
-	}
-	if err := os.WriteFile(dataFile, data, 0644); err != nil {
-		log.Printf("Error writing todo file: %v", err)
+// errDuplicate 表示开启去重时新增的待办与已有待办重复
+var errDuplicate = errors.New("todo already exists")
+
+// errEmptyTodo 表示待办文本去掉空白后为空
+var errEmptyTodo = errors.New("todo text is empty")
+
+// errTodoLimit 表示待办数量已达到 max_todos，新增被拒绝
+var errTodoLimit = errors.New("todo limit reached")
+
+// sameText 判断两段待办文本是否重复：去掉首尾空白后不区分大小写比较
+func sameText(a, b string) bool {
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
+
+// containsText 判断 todos 中是否已有与 text 重复的待办
+func containsText(todos []Todo, text string) bool {
+	for _, t := range todos {
+		if sameText(t.Text, text) {
+			return true
+		}
 	}
+	return false
 }
 
-func ensureIcon() string {
-	if _, err := os.Stat(iconFile); err == nil {
-		// 文件已存在，返回绝对路径
-		abs, _ := filepath.Abs(iconFile)
-		return abs
+// dedupTodos 去掉 incoming 中与 existing 或自身前面条目重复的待办
+func dedupTodos(existing, incoming []Todo) []Todo {
+	seen := append([]Todo(nil), existing...)
+	var kept []Todo
+	for _, t := range incoming {
+		if containsText(seen, t.Text) {
+			continue
+		}
+		seen = append(seen, t)
+		kept = append(kept, t)
 	}
-	// 文件不存在，创建它
-	img := image.NewRGBA(image.Rect(0, 0, 32, 32))
-	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{0, 0, 0, 0}}, image.Point{}, draw.Src)
-	black := color.RGBA{0, 0, 0, 255}
-	for y := 8; y <= 22; y += 7 {
-		for x := 8; x <= 22; x++ {
-			img.Set(x, y, black)
+	return kept
+}
+
+// extractTags 解析文本中的 #标签（支持全角＃），返回去重后的标签名，按出现顺序排列
+// 标签必须位于开头或空白之后，由字母、数字（含中文）、下划线和连字符组成
+func extractTags(text string) []string {
+	var tags []string
+	seen := map[string]bool{}
+	for _, field := range strings.Fields(text) {
+		rest, ok := strings.CutPrefix(field, "#")
+		if !ok {
+			rest, ok = strings.CutPrefix(field, "＃")
+		}
+		if !ok {
+			continue
+		}
+		end := strings.IndexFunc(rest, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-'
+		})
+		if end >= 0 {
+			rest = rest[:end]
+		}
+		if rest != "" && !seen[rest] {
+			seen[rest] = true
+			tags = append(tags, rest)
 		}
 	}
-	f, err := os.Create(iconFile)
-	if err != nil {
-		log.Printf("Failed to create icon file: %v", err)
-		return "" // 返回空字符串，Fyne可能会使用默认图标
+	return tags
+}
+
+// newID 生成一个随机的待办 ID
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand 在受支持的平台上不会失败，退回到时间戳以防万一
+		return fmt.Sprintf("%x", time.Now().UnixNano())
 	}
-	defer f.Close()
-	if err := png.Encode(f, img); err != nil {
-		log.Printf("Failed to encode icon: %v", err)
+	return hex.EncodeToString(b)
+}
+
+// indexByID 返回指定 ID 的待办下标，找不到时返回 -1
+func indexByID(todos []Todo, id string) int {
+	for i := range todos {
+		if todos[i].ID == id {
+			return i
+		}
 	}
-	abs, _ := filepath.Abs(iconFile)
-	return abs
+	return -1
 }
 
-// getExecutableDir 返回可执行文件所在的目录
-func getExecutableDir() (string, error) {
-	exePath, err := os.Executable()
-	if err != nil {
-		return "", err
+// findByID 在待办及其各级子任务中查找指定 ID，返回指向该项的指针，找不到时返回 nil
+func findByID(todos []Todo, id string) *Todo {
+	for i := range todos {
+		if todos[i].ID == id {
+			return &todos[i]
+		}
+		if t := findByID(todos[i].Subtasks, id); t != nil {
+			return t
+		}
 	}
-	return filepath.Dir(exePath), nil
+	return nil
 }
 
-/* ================= 单实例逻辑 ================= */
+// normalizeTodos 补全旧版本写出或手动编辑的文件中缺少的字段：ID（见 fillIDs）和从文本解析的 Tags，返回是否有改动
+func normalizeTodos(todos []Todo) bool {
+	changed := fillIDs(todos)
+	if fillTags(todos) {
+		changed = true
+	}
+	return changed
+}
 
-// runSingleInstanceCheck 检查是否已有实例在运行
-// 如果是，则发送信号并退出。如果不是，则启动监听并返回。
-// 返回一个布尔值，true表示当前进程是主实例，false表示是副本。
-func runSingleInstanceCheck() (bool, error) {
-	// 尝试连接到已存在的 socket
-	conn, err := net.Dial("unix", socketPath)
-	if err == nil {
-		// 连接成功，说明已有实例在运行
-		defer conn.Close()
-		// 发送 "show" 信号
-		_, err = conn.Write([]byte("show\n"))
-		if err != nil {
-			return false, fmt.Errorf("failed to send signal to existing instance: %w", err)
+// fillTags 为没有 Tags 字段的待办及其子任务从文本中解析标签，返回是否有改动
+func fillTags(todos []Todo) bool {
+	changed := false
+	for i := range todos {
+		if todos[i].Tags == nil {
+			if tags := extractTags(todos[i].Text); tags != nil {
+				todos[i].Tags = tags
+				changed = true
+			}
+		}
+		if fillTags(todos[i].Subtasks) {
+			changed = true
 		}
-		log.Println("Another instance is already running. Signaling it to show the window and exiting.")
-		return false, nil // false 表示不是主实例
 	}
+	return changed
+}
 
-	// 连接失败，说明没有实例在运行，当前进程成为主实例
-	// 启动一个 goroutine 来监听 socket
-	go func() {
-		// 清理旧的 socket 文件（如果存在）
-		_ = os.Remove(socketPath)
+// fillIDs 为待办及其各级子任务中缺少 ID 或 ID 重复的项分配新 ID，返回是否有改动
+// 手动编辑的文件可能缺少 ID 或复制出重复的 ID，此时 findByID 会命中错误的项
+func fillIDs(todos []Todo) bool {
+	return fillIDsSeen(todos, map[string]bool{})
+}
 
-		listener, err := net.Listen("unix", socketPath)
-		if err != nil {
-			log.Fatalf("Failed to create socket listener: %v", err)
+func fillIDsSeen(todos []Todo, seen map[string]bool) bool {
+	changed := false
+	for i := range todos {
+		if todos[i].ID == "" || seen[todos[i].ID] {
+			todos[i].ID = newID()
+			changed = true
 		}
-		defer listener.Close()
-		log.Printf("Socket listener started at %s", socketPath)
-
-		for {
-			conn, err := listener.Accept()
-			if err != nil {
-				log.Printf("Socket accept error: %v", err)
-				continue
-			}
-			go handleSocketConnection(conn)
+		seen[todos[i].ID] = true
+		if fillIDsSeen(todos[i].Subtasks, seen) {
+			changed = true
 		}
-	}()
+	}
+	return changed
+}
 
-	return true, nil // true 表示是主实例
+// subtaskProgress 返回直接子任务中已完成的数量和总数
+func subtaskProgress(t Todo) (done, total int) {
+	for _, st := range t.Subtasks {
+		if st.Done {
+			done++
+		}
+	}
+	return done, len(t.Subtasks)
 }
 
-// handleSocketConnection 处理来自新实例的连接
-func handleSocketConnection(conn net.Conn) {
-	defer conn.Close()
-	reader := bufio.NewReader(conn)
-	// 读取一行消息
-	message, err := reader.ReadString('\n')
-	if err != nil {
-		log.Printf("Failed to read from socket: %v", err)
-		return
+// 优先级取值
+const (
+	priorityNormal = 0
+	priorityHigh   = 1
+	priorityUrgent = 2
+)
+
+// parsePriority 解析文本开头的 "!"（高）或 "!!"（紧急），返回优先级和去掉标记后的文本
+// 全角的 "！" 同样识别，超过两个按紧急处理
+func parsePriority(text string) (int, string) {
+	rest := strings.TrimLeft(text, "!！")
+	n := utf8.RuneCountInString(text[:len(text)-len(rest)])
+	if n > priorityUrgent {
+		n = priorityUrgent
+	}
+	if n == priorityNormal {
+		return priorityNormal, text
 	}
+	return n, strings.TrimSpace(rest)
+}
 
-	message = strings.TrimSpace(message)
-	log.Printf("Received signal from new instance: %s", message)
+// dueDayOffsets 相对日期关键词到天数偏移的映射
+var dueDayOffsets = map[string]int{"今天": 0, "明天": 1, "后天": 2}
 
-	if message == "show" {
-		// 使用 fyne.Do 确保在主 goroutine 中执行 UI 操作
-		fyne.Do(func() {
-			// 假设 inputWin 是一个包级变量或可以通过闭包访问
-			// 在我们的代码结构中，需要将 inputWin 提升或通过其他方式访问
-			// 这里我们通过一个技巧：在 main 函数中定义一个 showWindow 函数
-			if showWindow != nil {
-				showWindow()
-			}
-		})
+// dueWeekdays 星期关键词（周X / 星期X）到 time.Weekday 的映射
+var dueWeekdays = map[string]time.Weekday{
+	"一": time.Monday, "二": time.Tuesday, "三": time.Wednesday, "四": time.Thursday,
+	"五": time.Friday, "六": time.Saturday, "日": time.Sunday, "天": time.Sunday,
+}
+
+// parseDue 解析文本开头的日期关键词（今天/明天/后天、周一…周日、星期一…星期日），
+// 关键词后需有空白。返回截止时间（当天 23:59:59）、去掉关键词后的文本以及是否识别成功
+// 星期取从今天起最近的那一天，当天即为今天；无法识别时原样返回文本
+func parseDue(text string, now time.Time) (time.Time, string, bool) {
+	trimmed := strings.TrimSpace(text)
+	i := strings.IndexFunc(trimmed, unicode.IsSpace)
+	if i < 0 {
+		return time.Time{}, text, false
+	}
+	word, rest := trimmed[:i], strings.TrimSpace(trimmed[i:])
+	if rest == "" {
+		return time.Time{}, text, false
+	}
+	days, ok := dueDayOffsets[word]
+	if !ok {
+		var wd time.Weekday
+		if day, cut := strings.CutPrefix(word, "星期"); cut {
+			wd, ok = dueWeekdays[day]
+		} else if day, cut := strings.CutPrefix(word, "周"); cut {
+			wd, ok = dueWeekdays[day]
+		}
+		if !ok {
+			return time.Time{}, text, false
+		}
+		days = (int(wd) - int(now.Weekday()) + 7) % 7
 	}
+	y, m, d := now.Date()
+	due := time.Date(y, m, d+days, 23, 59, 59, 0, now.Location())
+	return due, rest, true
 }
 
-/* ================= Main ================= */
+// priorityBadge 返回优先级对应的标记前缀
+func priorityBadge(p int) string {
+	switch p {
+	case priorityUrgent:
+		return "🔴 "
+	case priorityHigh:
+		return "🟡 "
+	}
+	return ""
+}
 
-// showWindow 是一个函数变量，用于在 socket 信号到达时调用
-var showWindow func()
+// isOverdue 判断待办在 now 时是否已逾期（已完成的不算）
+func isOverdue(t Todo, now time.Time) bool {
+	return !t.Done && t.Due != nil && t.Due.Before(now)
+}
 
-func main() {
-	// 1. 初始化路径
-	var err error
-	configDir, err = getExecutableDir()
-	if err != nil {
-		// 如果获取失败，使用当前目录作为备选
-		log.Printf("Warning: could not get executable directory: %v. Using current directory.", err)
-		configDir, _ = os.Getwd()
+// dueKey 标识一次到期提醒；重复待办顺延后截止时间变化，会得到新的 key
+func dueKey(t Todo) string {
+	return t.ID + "@" + strconv.FormatInt(t.Due.Unix(), 10)
+}
+
+// collectDue 返回已到期且尚未提醒过的待办，并把它们记入 notified
+func collectDue(todos []Todo, now time.Time, notified map[string]bool) []Todo {
+	var due []Todo
+	for _, t := range todos {
+		if !isOverdue(t, now) || notified[dueKey(t)] {
+			continue
+		}
+		notified[dueKey(t)] = true
+		due = append(due, t)
 	}
-	dataFile = filepath.Join(configDir, "todo.json")
-	iconFile = filepath.Join(configDir, "tray.png")
+	return due
+}
 
-	// 设置 socket 路径，通常放在用户缓存目录或 /tmp 下更规范
-	// 为了简单和权限问题，我们放在 /tmp 下，并加上用户名以避免冲突
-	currentUser, err := user.Current()
+// nextClockTime 返回 now 之后最近一次到达每日时刻 clock（"HH:MM"）的时间
+func nextClockTime(now time.Time, clock string) (time.Time, error) {
+	c, err := time.Parse("15:04", clock)
 	if err != nil {
-		// 如果获取用户失败，使用一个通用名称
-		socketPath = filepath.Join("/tmp", "todo-app.sock")
-	} else {
-		socketPath = filepath.Join("/tmp", fmt.Sprintf("todo-app-%s.sock", currentUser.Username))
+		return time.Time{}, err
 	}
+	next := time.Date(now.Year(), now.Month(), now.Day(), c.Hour(), c.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next, nil
+}
 
-	// 2. 单实例检查
-	isMainInstance, err := runSingleInstanceCheck()
-	if err != nil {
-		log.Fatalf("Single instance check failed: %v", err)
+// dailySummary 返回每日汇总提醒的内容：未完成与已逾期的待办数量
+func dailySummary(todos []Todo, now time.Time) string {
+	open, overdue := 0, 0
+	for _, t := range todos {
+		if t.Done {
+			continue
+		}
+		open++
+		if isOverdue(t, now) {
+			overdue++
+		}
 	}
-	if !isMainInstance {
-		// 如果不是主实例，直接退出
-		os.Exit(0)
+	if open == 0 {
+		return "没有未完成的待办"
 	}
+	if overdue == 0 {
+		return fmt.Sprintf("共 %d 条未完成", open)
+	}
+	return fmt.Sprintf("共 %d 条未完成，其中 %d 条已逾期", open, overdue)
+}
 
-	// --- 以下是主实例的逻辑 ---
+// displayOrder 返回托盘中的显示顺序（todos 的下标）
+// 置顶项始终在最前，同组内逾期项在前，其次按优先级从高到低；alpha 为 true 时同级按文本排序（中文按拼音），
+// 否则保持插入顺序，reverse 为 true 时改为最新的在前。只返回下标，不改变 todos 本身的顺序
+func displayOrder(todos []Todo, now time.Time, alpha, reverse bool) []int {
+	order := make([]int, len(todos))
+	for i := range order {
+		order[i] = i
+		if reverse {
+			order[i] = len(todos) - 1 - i
+		}
+	}
+	col := collate.New(language.Chinese)
+	sort.SliceStable(order, func(a, b int) bool {
+		ta, tb := todos[order[a]], todos[order[b]]
+		if ta.Pinned != tb.Pinned {
+			return ta.Pinned
+		}
+		if oa, ob := isOverdue(ta, now), isOverdue(tb, now); oa != ob {
+			return oa
+		}
+		if ta.Priority != tb.Priority || !alpha {
+			return ta.Priority > tb.Priority
+		}
+		return col.CompareString(ta.Text, tb.Text) < 0
+	})
+	return order
+}
 
-	a := app.NewWithID(appID)
-	todos := loadTodos()
+// moveTarget 返回 id 对应的待办在显示顺序 order 中上方（delta 为 -1）或下方（1）相邻项的 ID
+// 只在同一分区（未打标签，或第一个标签相同）内查找；相邻项的置顶、逾期或优先级不同时，交换存储位置不会改变显示顺序，
+// 此时与到达边界时一样返回空字符串。交换两者在存储中的位置即可让它们在托盘中互换，倒序显示时也是如此
+func moveTarget(todos []Todo, order []int, id string, delta int, now time.Time) string {
+	pos := -1
+	for p, i := range order {
+		if todos[i].ID == id {
+			pos = p
+			break
+		}
+	}
+	if pos < 0 {
+		return ""
+	}
+	t := todos[order[pos]]
+	for p := pos + delta; p >= 0 && p < len(order); p += delta {
+		o := todos[order[p]]
+		if firstTag(o) != firstTag(t) {
+			continue
+		}
+		if o.Pinned != t.Pinned || isOverdue(o, now) != isOverdue(t, now) || o.Priority != t.Priority {
+			return ""
+		}
+		return o.ID
+	}
+	return ""
+}
 
-	// 将窗口和托盘相关变量定义在 main 作用域内
-	var inputWin fyne.Window
-	var tray desktop.App
-	var rebuildTray func()
+// firstTag 返回待办的第一个标签，没有标签时返回空字符串
+func firstTag(t Todo) string {
+	if len(t.Tags) == 0 {
+		return ""
+	}
+	return t.Tags[0]
+}
 
-	// 定义 showWindow 函数，使其可以被 socket 处理器调用
-	showWindow = func() {
-		if inputWin != nil {
-			inputWin.Show()
-			inputWin.RequestFocus()
+// todoStats 是托盘中展示的统计信息
+type todoStats struct {
+	Total     int           // 待办总数
+	DoneToday int           // 今天完成的数量
+	AvgAge    time.Duration // 有创建时间的待办的平均存在时长，没有时为 0
+}
+
+// stats 统计待办数量、今日完成数和平均存在时长
+func stats(todos []Todo, now time.Time) todoStats {
+	st := todoStats{Total: len(todos)}
+	y, m, d := now.Date()
+	var age time.Duration
+	aged := 0
+	for _, t := range todos {
+		if t.Done && t.DoneAt != nil {
+			if ty, tm, td := t.DoneAt.In(now.Location()).Date(); ty == y && tm == m && td == d {
+				st.DoneToday++
+			}
 		}
+		if !t.Created.IsZero() {
+			age += now.Sub(t.Created)
+			aged++
+		}
+	}
+	if aged > 0 {
+		st.AvgAge = age / time.Duration(aged)
 	}
+	return st
+}
 
-	inputWin = a.NewWindow("新增待办")
-	entry := widget.NewEntry()
-	entry.SetPlaceHolder("输入待办事项...")
+/* ================= 工具函数 ================= */
 
-	leftTips := canvas.NewText(fmt.Sprintf("剩余: %d", maxWeight), color.NRGBA{128, 128, 128, 255})
-	leftTips.TextSize = 10
+// 权重计算模式
+const (
+	weightModeMixed   = "mixed"   // 东亚宽字符2，其他1
+	weightModeUniform = "uniform" // 每个字符（字形簇）均为1
+)
 
-	rightTips := canvas.NewText("按回车提交", color.NRGBA{150, 150, 150, 200})
-	rightTips.TextSize = 10
-	rightTips.Alignment = fyne.TextAlignTrailing
+// clusterWeight 返回一个字形簇（用户看到的一个字符，如 emoji 的 ZWJ 序列、带组合符号的字母）的权重
+// mixed 模式按等宽显示宽度计算：汉字、假名、谚文、全角字符和 emoji 计2，其他计1
+func clusterWeight(cluster string, mode string) int {
+	if mode == weightModeUniform {
+		return 1
+	}
+	if uniseg.StringWidth(cluster) >= 2 {
+		return 2
+	}
+	return 1
+}
 
-	showSuccess := func() {
-		rightTips.Text = "√ 待办已提交"
-		rightTips.Color = color.NRGBA{50, 205, 50, 255}
-		rightTips.Refresh()
-		go func() {
-			time.Sleep(time.Second * 2)
-			fyne.Do(func() {
-				rightTips.Text = "按回车提交"
-				rightTips.Color = color.NRGBA{150, 150, 150, 200}
-				rightTips.Refresh()
-			})
-		}()
+// 计算字符串权重，按字形簇逐个累加
+func getWeight(s string, mode string) int {
+	w := 0
+	g := uniseg.NewGraphemes(s)
+	for g.Next() {
+		w += clusterWeight(g.Str(), mode)
 	}
+	return w
+}
 
-	entry.OnChanged = func(s string) {
-		currentW := getWeight(s)
-		if currentW > maxWeight {
-			entry.SetText(truncateByWeight(s, maxWeight))
-			return
+// 根据权重截断字符串，如果被截断则添加省略号
+func truncateByWeightWithEllipsis(s string, maxW int, mode string) string {
+	if getWeight(s, mode) <= maxW {
+		return s
+	}
+	return truncateByWeight(s, maxW, mode) + "…"
+}
+
+// 基础截断（不带省略号，用于输入框强制限制），不会从字形簇中间截断
+func truncateByWeight(s string, maxW int, mode string) string {
+	currW := 0
+	var b strings.Builder
+	g := uniseg.NewGraphemes(s)
+	for g.Next() {
+		itemW := clusterWeight(g.Str(), mode)
+		if currW+itemW > maxW {
+			break
 		}
-		leftTips.Text = fmt.Sprintf("剩余: %d", maxWeight-currentW)
-		leftTips.Refresh()
+		currW += itemW
+		b.WriteString(g.Str())
 	}
+	return b.String()
+}
 
-	bottomBar := container.New(layout.NewHBoxLayout(),
-		leftTips,
-		layout.NewSpacer(),
-		rightTips,
-	)
-	content := container.NewPadded(
-		container.NewBorder(nil, bottomBar, nil, nil, entry),
-	)
+// inputTips 返回输入框下方的提示：已输入的字符（字形簇）数和按 mode 计算的剩余权重
+func inputTips(s string, limit int, mode string) string {
+	return fmt.Sprintf("字数 %d / 余 %d", uniseg.GraphemeClusterCount(s), limit-getWeight(s, mode))
+}
 
-	inputWin.SetContent(content)
-	inputWin.Resize(fyne.NewSize(320, 85))
-	inputWin.SetFixedSize(true)
-	inputWin.SetCloseIntercept(func() { inputWin.Hide() })
+// singleLine 将换行替换为空格，用于在托盘菜单中单行显示
+func singleLine(s string) string {
+	return strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(s)
+}
 
-	var ok bool
+// expandAbbreviations 将 text 中与缩写表完全匹配的词（以空白分隔）替换为展开内容
+// 匹配区分大小写，只替换整个词，不替换词中的子串
+func expandAbbreviations(text string, abbr map[string]string) string {
+	if len(abbr) == 0 {
+		return text
+	}
+	var b strings.Builder
+	start := -1
+	flush := func(end int) {
+		word := text[start:end]
+		if rep, ok := abbr[word]; ok {
+			word = rep
+		}
+		b.WriteString(word)
+		start = -1
+	}
+	for i, r := range text {
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				flush(i)
+			}
+			b.WriteRune(r)
+		} else if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		flush(len(text))
+	}
+	return b.String()
+}
+
+// splitLastToken 将 s 拆分为最后一个空白分隔的词及其之前的部分
+func splitLastToken(s string) (prefix, token string) {
+	i := strings.LastIndexFunc(s, unicode.IsSpace)
+	if i < 0 {
+		return "", s
+	}
+	_, size := utf8.DecodeRuneInString(s[i:])
+	return s[:i+size], s[i+size:]
+}
+
+// splitLines 将多行文本拆分为去掉首尾空白后的非空行
+func splitLines(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// cleanTodoText 去掉待办文本首尾的空白，全部为空白时返回空字符串
+// collapse 为 true 时还把每行内连续的空白合并为一个空格，保留换行
+func cleanTodoText(text string, collapse bool) string {
+	text = strings.TrimSpace(text)
+	if !collapse {
+		return text
+	}
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+/* ================= 输入框 ================= */
+
+// todoEntry 是输入窗口使用的输入框
+// Fyne 的单行输入框粘贴时会把换行替换成空格，这里保留换行，提交时再按行拆分为多条待办
+type todoEntry struct {
+	widget.Entry
+	// onEscape 在按下 Esc 时调用，为 nil 时交给 Entry 处理
+	onEscape func()
+	// onDigit 在输入框为空时按下 1-9 调用，返回 false 时照常输入该数字
+	onDigit func(n int) bool
+}
+
+func newTodoEntry() *todoEntry {
+	e := &todoEntry{}
+	e.ExtendBaseWidget(e)
+	return e
+}
+
+// TypedKey 拦截 Esc，其余按键交给 Entry 处理
+func (e *todoEntry) TypedKey(key *fyne.KeyEvent) {
+	if key.Name == fyne.KeyEscape && e.onEscape != nil {
+		e.onEscape()
+		return
+	}
+	e.Entry.TypedKey(key)
+}
+
+// TypedRune 在输入框为空时把 1-9 交给 onDigit 处理，其余字符照常输入
+func (e *todoEntry) TypedRune(r rune) {
+	if e.Text == "" && r >= '1' && r <= '9' && e.onDigit != nil && e.onDigit(int(r-'0')) {
+		return
+	}
+	e.Entry.TypedRune(r)
+}
+
+// TypedShortcut 在单行模式下粘贴多行文本时将其追加到末尾并保留换行，其余快捷键交给 Entry 处理
+func (e *todoEntry) TypedShortcut(shortcut fyne.Shortcut) {
+	if paste, ok := shortcut.(*fyne.ShortcutPaste); ok && !e.MultiLine {
+		if text := paste.Clipboard.Content(); len(splitLines(text)) > 1 {
+			e.Append(text)
+			return
+		}
+	}
+	e.Entry.TypedShortcut(shortcut)
+}
+
+/* ================= 配置 ================= */
+
+// Config 对应 config.json 中的用户配置，缺失的字段使用零值
+type Config struct {
+	// Abbreviations 缩写展开表，例如 "brb" → "be right back"
+	Abbreviations map[string]string `json:"abbreviations,omitempty"`
+	// WeightMode 字数权重模式："mixed"（默认，中文计2）或 "uniform"（每字计1）
+	WeightMode string `json:"weight_mode,omitempty"`
+	// Focus 当前专注的事项，显示在托盘菜单顶部，为空则不显示
+	Focus string `json:"focus,omitempty"`
+	// MultiLine 多行输入模式：回车换行，Shift+回车或“提交”按钮提交
+	MultiLine bool `json:"multi_line,omitempty"`
+	// ConfirmDelete 删除待办前弹出确认对话框
+	ConfirmDelete bool `json:"confirm_delete,omitempty"`
+	// CompactMenu 紧凑菜单：新增条目兼作标题行并显示数量，省略多余的分隔线
+	CompactMenu bool `json:"compact_menu,omitempty"`
+	// AutoQuitIdleMinutes 窗口隐藏且无操作超过该分钟数后自动保存并退出，0 表示从不
+	// 一小时内有待办到期时不退出；退出后到期提醒不会发出，需依赖自启动或再次运行来重新打开
+	AutoQuitIdleMinutes int `json:"auto_quit_idle_minutes,omitempty"`
+	// Hooks 事件（on_add / on_done）到 shell 命令的映射，未配置则不执行
+	// 注意：命令以当前用户身份通过 sh -c 执行，请只配置自己信任的命令
+	Hooks map[string]string `json:"hooks,omitempty"`
+	// Backups 保存时保留的 todo.json 历史版本数量（todo.json.1 为最新），0 表示不备份
+	Backups int `json:"backups"`
+	// Hotkey 呼出输入窗口的全局快捷键，例如 "ctrl+shift+t"，为空则不注册
+	// 修饰键可用 ctrl / shift / alt / super，主键可用字母、数字、space、return、f1-f12
+	Hotkey string `json:"hotkey,omitempty"`
+	// SortAlpha 托盘中按文本排序显示（中文按拼音），只影响显示，不改变保存顺序
+	SortAlpha bool `json:"sort_alpha,omitempty"`
+	// Reverse 托盘中最新添加的待办显示在前，只影响显示，不改变保存顺序
+	Reverse bool `json:"reverse,omitempty"`
+	// MaxTrayItems 托盘中直接列出的待办数量上限，其余放入“更多…”子菜单，0 表示不限制
+	MaxTrayItems int `json:"max_tray_items"`
+	// MaxWeight 输入内容的权重上限，不大于 0 时使用默认值 40
+	MaxWeight int `json:"max_weight"`
+	// MaxShowWeight 托盘菜单中文本显示的权重上限，超出部分以省略号代替，不大于 0 时使用默认值 40
+	MaxShowWeight int `json:"max_show_weight"`
+	// LogFile 主实例的日志文件路径，默认为数据目录下的 mytodo.log，为空则只输出到 stderr
+	LogFile string `json:"log_file"`
+	// Icon 自定义托盘图标文件（PNG、JPEG 或 SVG），为空或无法解码时使用内置图标；SVG 图标不显示未完成数量角标
+	Icon string `json:"icon,omitempty"`
+	// List 当前使用的列表名称，为空表示默认列表
+	List string `json:"list,omitempty"`
+	// FuzzyFilter 托盘筛选使用模糊匹配（按顺序包含各关键字的字符即可），结果按匹配程度排序；默认为精确包含
+	FuzzyFilter bool `json:"fuzzy_filter,omitempty"`
+	// MaxTodos 待办数量上限（含已完成未归档的），达到后拒绝新增，0 表示不限制
+	MaxTodos int `json:"max_todos,omitempty"`
+	// MaxTodosWarnOnly 达到 MaxTodos 后仍允许新增，只给出提示
+	MaxTodosWarnOnly bool `json:"max_todos_warn_only,omitempty"`
+	// SummaryTime 每天发送待办汇总通知的时刻，格式为 "HH:MM"（如 "09:00"），为空则不发送
+	SummaryTime string `json:"summary_time,omitempty"`
+	// CollapseSpaces 保存待办前把每行内连续的空白合并为一个空格
+	CollapseSpaces bool `json:"collapse_spaces,omitempty"`
+	// Theme 界面和托盘图标的明暗："auto"（默认，跟随系统）、"light" 或 "dark"
+	Theme string `json:"theme,omitempty"`
+	// Dedup 新增或导入时跳过与已有待办重复的文本（忽略首尾空白和大小写）
+	Dedup bool `json:"dedup,omitempty"`
+	// Encrypt 以口令加密保存待办数据（AES-GCM），口令取自环境变量 MYTODO_PASSPHRASE，未设置时启动后弹窗询问
+	// 口令不会写入任何文件，遗忘后数据无法恢复；已经加密的数据文件即使关闭此项也继续加密保存
+	Encrypt bool `json:"encrypt,omitempty"`
+	// SuccessMessage 提交成功后在输入窗口右下角显示的提示，为空时使用默认提示
+	SuccessMessage string `json:"success_message,omitempty"`
+	// SuccessSeconds 提交成功提示的显示秒数，0 表示使用默认值 2 秒
+	SuccessSeconds int `json:"success_seconds,omitempty"`
+}
+
+// defaultConfig 返回默认配置，配置文件中缺失的字段保持这些值
+func defaultConfig() Config {
+	return Config{
+		WeightMode:    weightModeMixed,
+		MaxWeight:     maxWeight,
+		MaxShowWeight: maxShowWeight,
+		Backups:       5,
+		MaxTrayItems:  15,
+		LogFile:       filepath.Join(dataDir, "mytodo.log"),
+	}
+}
+
+// inputLimit 返回输入内容的权重上限，配置值不合法时回退到默认值
+func (c Config) inputLimit() int {
+	if c.MaxWeight <= 0 {
+		return maxWeight
+	}
+	return c.MaxWeight
+}
+
+// displayLimit 返回托盘显示文本的权重上限，配置值不合法时回退到默认值
+func (c Config) displayLimit() int {
+	if c.MaxShowWeight <= 0 {
+		return maxShowWeight
+	}
+	return c.MaxShowWeight
+}
+
+// focusHeader 返回托盘顶部的专注标题行，没有设置专注时返回空字符串
+func (c Config) focusHeader() string {
+	if c.Focus == "" {
+		return ""
+	}
+	return "正在专注: " + truncateByWeightWithEllipsis(singleLine(c.Focus), c.displayLimit(), c.WeightMode)
+}
+
+// successMessage 返回提交成功后显示的提示，未配置时使用默认提示
+func (c Config) successMessage() string {
+	if c.SuccessMessage == "" {
+		return "√ 待办已提交"
+	}
+	return c.SuccessMessage
+}
+
+// successDuration 返回提交成功提示的显示时长，未配置时为 2 秒
+func (c Config) successDuration() time.Duration {
+	if c.SuccessSeconds <= 0 {
+		return 2 * time.Second
+	}
+	return time.Duration(c.SuccessSeconds) * time.Second
+}
+
+// 支持的钩子事件
+var hookEvents = map[string]bool{"on_add": true, "on_done": true}
+
+// loadConfig 读取 config.json，缺失的字段保持默认值
+// 首次运行（文件不存在）时写出一份默认配置，便于用户在此基础上修改
+// 文件损坏或取值不合法时记录警告并使用默认配置，不会中止启动
+func loadConfig() Config {
+	cfg := defaultConfig()
+	data, err := os.ReadFile(configFile)
+	if os.IsNotExist(err) {
+		if err := saveConfig(cfg); err != nil {
+			log.Printf("Error creating default config file: %v", err)
+		}
+		return cfg
+	}
+	if err != nil {
+		log.Printf("Error reading config file: %v", err)
+		return cfg
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		log.Printf("Warning: malformed config file %s, using defaults: %v", configFile, err)
+		return defaultConfig()
+	}
+	if err := validateConfig(cfg); err != nil {
+		log.Printf("Warning: invalid config file %s, using defaults: %v", configFile, err)
+		return defaultConfig()
+	}
+	return cfg
+}
+
+func saveConfig(cfg Config) error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal config: %w", err)
+	}
+	if err := os.WriteFile(configFile, data, 0600); err != nil {
+		return fmt.Errorf("write config file: %w", err)
+	}
+	return nil
+}
+
+// mergeConfig 把 JSON 对象 data 中出现的字段写到 cur 的副本上，并检查合并后的配置；出错时返回 cur
+// 未出现的字段保持 cur 中的值；abbreviations、hooks 等映射整体替换而不是逐项合并，以便删除其中的条目
+func mergeConfig(cur Config, data []byte) (Config, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return cur, fmt.Errorf("invalid config JSON: %w", err)
+	}
+	cfg := cur
+	// 复制映射，避免解码时改动 cur 共享的映射
+	cfg.Abbreviations = maps.Clone(cur.Abbreviations)
+	cfg.Hooks = maps.Clone(cur.Hooks)
+	if _, ok := fields["abbreviations"]; ok {
+		cfg.Abbreviations = nil
+	}
+	if _, ok := fields["hooks"]; ok {
+		cfg.Hooks = nil
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cur, fmt.Errorf("invalid config JSON: %w", err)
+	}
+	if err := validateConfig(cfg); err != nil {
+		return cur, err
+	}
+	return cfg, nil
+}
+
+// validateConfig 检查配置取值是否合法
+func validateConfig(cfg Config) error {
+	switch cfg.WeightMode {
+	case "", weightModeMixed, weightModeUniform:
+	default:
+		return fmt.Errorf("invalid weight_mode %q", cfg.WeightMode)
+	}
+	for k := range cfg.Abbreviations {
+		if k == "" || strings.IndexFunc(k, unicode.IsSpace) >= 0 {
+			return fmt.Errorf("invalid abbreviation %q: must be a single non-empty word", k)
+		}
+	}
+	if cfg.Backups < 0 {
+		return fmt.Errorf("invalid backups %d: must not be negative", cfg.Backups)
+	}
+	switch cfg.Theme {
+	case "", "auto", "light", "dark":
+	default:
+		return fmt.Errorf("invalid theme %q: want auto, light or dark", cfg.Theme)
+	}
+	if cfg.SummaryTime != "" {
+		if _, err := nextClockTime(time.Now(), cfg.SummaryTime); err != nil {
+			return fmt.Errorf("invalid summary_time %q: want HH:MM", cfg.SummaryTime)
+		}
+	}
+	if cfg.MaxTodos < 0 {
+		return fmt.Errorf("invalid max_todos %d: must not be negative", cfg.MaxTodos)
+	}
+	if cfg.MaxTrayItems < 0 {
+		return fmt.Errorf("invalid max_tray_items %d: must not be negative", cfg.MaxTrayItems)
+	}
+	if cfg.SuccessSeconds < 0 {
+		return fmt.Errorf("invalid success_seconds %d: must not be negative", cfg.SuccessSeconds)
+	}
+	if cfg.AutoQuitIdleMinutes < 0 {
+		return fmt.Errorf("invalid auto_quit_idle_minutes %d: must not be negative", cfg.AutoQuitIdleMinutes)
+	}
+	for event := range cfg.Hooks {
+		if !hookEvents[event] {
+			return fmt.Errorf("unknown hook event %q", event)
+		}
+	}
+	if cfg.List != "" {
+		if err := validateListName(cfg.List); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// idleExpired 判断自 last 起到 now 的空闲时间是否已达到 minutes 分钟，minutes 为 0 表示从不过期
+func idleExpired(last, now time.Time, minutes int) bool {
+	return minutes > 0 && now.Sub(last) >= time.Duration(minutes)*time.Minute
+}
+
+// autoQuitDueWindow 为空闲自动退出前检查的提醒窗口：此时间内有待办到期时不退出，以免错过提醒
+const autoQuitDueWindow = time.Hour
+
+// dueWithin 判断是否有未完成的待办将在 now 之后的 d 时间内到期，已逾期的不算（其提醒已经发出）
+func dueWithin(todos []Todo, now time.Time, d time.Duration) bool {
+	for _, t := range todos {
+		if !t.Done && t.Due != nil && t.Due.After(now) && !t.Due.After(now.Add(d)) {
+			return true
+		}
+	}
+	return false
+}
+
+/* ================= 钩子 ================= */
+
+// runHook 在后台执行 event 对应的钩子命令
+// 待办文本既作为 $1 传给命令，也写入其标准输入；输出记录到日志
+func runHook(event string, t Todo) {
+	cmdline := config.Hooks[event]
+	if cmdline == "" {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, "sh", "-c", cmdline, "mytodo-"+event, t.Text)
+		cmd.Stdin = strings.NewReader(t.Text + "\n")
+		out, err := cmd.CombinedOutput()
+		if len(out) > 0 {
+			log.Printf("Hook %s output: %s", event, strings.TrimSpace(string(out)))
+		}
+		if err != nil {
+			log.Printf("Hook %s failed: %v", event, err)
+		}
+	}()
+}
+
+/* ================= 数据读写 ================= */
+
+// cliStore 返回读写启动时选定的数据文件（dataFile）的 fileStore，供不启动界面的命令行子命令使用
+func cliStore() *fileStore {
+	return newFileStore(dataFile, fileCodec{}, func() int { return config.Backups })
+}
+
+// loadTodos 读取 dataFile，错误含义见 fileStore.Load
+func loadTodos() ([]Todo, error) {
+	s := cliStore()
+	err := s.Load()
+	return s.All(), err
+}
+
+// dataVersion 为当前 todo.json 的格式版本
+// 版本 1 是顶层为待办数组的旧格式，版本 2 起使用 todoFile 封装
+const dataVersion = 2
+
+// todoFile 对应 todo.json 的封装格式
+type todoFile struct {
+	Version int    `json:"version"`
+	Todos   []Todo `json:"todos"`
+}
+
+// errUnsupportedData 表示数据文件的格式版本或加密方式不被本程序支持，通常由更新版本的程序写出
+// 这类文件既不能当作损坏的文件改名，也不能被覆盖
+var errUnsupportedData = errors.New("todo data file uses an unsupported format, it was probably written by a newer version of mytodo")
+
+// migrateData 解析 todo.json 的内容，旧的数组格式会被升级，此时 migrated 为 true
+// 版本号高于当前程序支持的版本时返回包装了 errUnsupportedData 的错误，避免用旧程序改写新格式的数据
+// 加密的文件先解密，再按明文格式解析
+func migrateData(data []byte) (todos []Todo, migrated bool, err error) {
+	trimmed := bytes.TrimSpace(data)
+	if plain, encrypted, err := decryptData(trimmed); encrypted {
+		if err != nil {
+			return nil, false, err
+		}
+		trimmed = bytes.TrimSpace(plain)
+	}
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &todos); err != nil {
+			return nil, false, err
+		}
+		return todos, true, nil
+	}
+	var f todoFile
+	if err := json.Unmarshal(trimmed, &f); err != nil {
+		return nil, false, err
+	}
+	if f.Version > dataVersion {
+		return nil, false, fmt.Errorf("%w: version %d (newest supported is %d)", errUnsupportedData, f.Version, dataVersion)
+	}
+	if f.Todos == nil {
+		f.Todos = []Todo{}
+	}
+	return f.Todos, f.Version < dataVersion, nil
+}
+
+// encodeTodos 将待办编码为当前版本的 todo.json 内容，开启加密时返回加密后的内容（见 encodeData）
+func encodeTodos(todos []Todo) ([]byte, error) {
+	if todos == nil {
+		todos = []Todo{}
+	}
+	data, err := json.MarshalIndent(todoFile{Version: dataVersion, Todos: todos}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return encodeData(data)
+}
+
+// encodeData 编码要写入数据目录的内容（待办、归档、回收站、草稿和完成记录），开启加密时返回加密后的内容
+// 缺少可用口令时返回 errLocked，避免以明文或错误口令覆盖已加密的数据
+func encodeData(plain []byte) ([]byte, error) {
+	if dataLocked() {
+		return nil, errLocked
+	}
+	if !encryptionEnabled() {
+		return plain, nil
+	}
+	return encryptData(plain)
+}
+
+// decodeData 还原 encodeData 写出的内容，未加密的内容（包括旧版本写出的明文文件）原样返回
+func decodeData(data []byte) ([]byte, error) {
+	plain, encrypted, err := decryptData(bytes.TrimSpace(data))
+	if !encrypted {
+		return data, nil
+	}
+	return plain, err
+}
+
+// rotateBackups 把 path 的已有备份依次后移（.1 → .2 …），再将当前文件复制为 .1
+// 最多保留 keep 个，多出的旧备份会被删除；keep 为 0 时删除全部备份
+func rotateBackups(path string, keep int) error {
+	backup := func(i int) string { return fmt.Sprintf("%s.%d", path, i) }
+	// 清理超出数量的旧备份（例如调小了保留数量）
+	for i := keep + 1; ; i++ {
+		if err := os.Remove(backup(i)); err != nil {
+			break
+		}
+	}
+	if keep == 0 {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for i := keep - 1; i >= 1; i-- {
+		if err := os.Rename(backup(i), backup(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.WriteFile(backup(1), data, 0600)
+}
+
+// saveRetries 为写入数据文件失败后的重试次数，每次重试前等待的时间依次加倍
+const saveRetries = 2
+
+// writeFileRetry 写入文件，失败时短暂等待后重试；权限错误不会因重试而好转，直接返回
+func writeFileRetry(path string, data []byte, perm os.FileMode) error {
+	backoff := 100 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		err := os.WriteFile(path, data, perm)
+		if err == nil || attempt == saveRetries || errors.Is(err, os.ErrPermission) {
+			return err
+		}
+		log.Printf("Error writing %s, retrying in %v: %v", path, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// saveTodos 将 todos 写入 dataFile
+func saveTodos(todos []Todo) error {
+	s := cliStore()
+	s.Replace(todos)
+	return s.Save()
+}
+
+/* ================= 加密 ================= */
+
+// scryptParams 为由口令派生密钥时 scrypt 的参数：N=2^15、r=8、p=1，约占 32MB 内存
+// 读取文件时使用文件中记录的参数，N 超过 maxScryptN 的视为损坏，避免打开文件时耗尽内存
+var scryptParams = kdfParams{N: 1 << 15, R: 8, P: 1}
+
+const maxScryptN = 1 << 20
+
+// kdfParams 是 scrypt 的成本参数
+type kdfParams struct {
+	N int `json:"n"`
+	R int `json:"r"`
+	P int `json:"p"`
+}
+
+// errLocked 表示数据文件已加密而当前没有可用的口令，errNoPassphrase 与 errWrongPassphrase 都包装了它
+var (
+	errLocked          = errors.New("todo data file is encrypted and locked")
+	errNoPassphrase    = fmt.Errorf("%w: no passphrase is set (set MYTODO_PASSPHRASE or enter it at startup)", errLocked)
+	errWrongPassphrase = fmt.Errorf("%w: wrong passphrase or corrupted file", errLocked)
+)
+
+// encryptedFile 对应加密后的 todo.json，Data 为 AES-256-GCM 加密的明文 todo.json，密钥由 scrypt 派生
+type encryptedFile struct {
+	Version int    `json:"version"`
+	Cipher  string `json:"cipher"`
+	KDF     string `json:"kdf"`
+	kdfParams
+	Salt  []byte `json:"salt"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+// 口令及派生出的密钥，派生开销较大，按盐值缓存以免每次保存都重新计算
+var (
+	keyMu      sync.Mutex
+	passphrase string
+	// passphraseRejected 表示磁盘上的加密文件无法用当前口令（或在没有口令时）解密
+	passphraseRejected bool
+	// dataEncrypted 表示本次运行中读到过加密的数据文件
+	dataEncrypted     bool
+	keepEncryptedOnce sync.Once
+	keySalt           []byte
+	keyParams         kdfParams
+	key               []byte
+)
+
+// setPassphrase 设置口令并清除之前的解密失败状态和密钥缓存
+func setPassphrase(p string) {
+	keyMu.Lock()
+	defer keyMu.Unlock()
+	passphrase = p
+	passphraseRejected = false
+	keySalt, keyParams, key = nil, kdfParams{}, nil
+}
+
+// dataLocked 报告当前是否因缺少可用口令而不能保存待办
+func dataLocked() bool {
+	keyMu.Lock()
+	defer keyMu.Unlock()
+	return passphraseRejected || ((config.Encrypt || dataEncrypted) && passphrase == "")
+}
+
+// encryptionEnabled 报告保存时是否加密：配置开启了加密，或者读到的数据文件本身是加密的
+// 后者保证配置被重置（例如 config.json 损坏或取值不合法时回退到默认配置）时不会把已加密的待办以明文写回磁盘
+func encryptionEnabled() bool {
+	keyMu.Lock()
+	defer keyMu.Unlock()
+	if dataEncrypted && !config.Encrypt {
+		keepEncryptedOnce.Do(func() {
+			log.Printf("Warning: encrypt is off in config but the todo file is encrypted, keeping it encrypted")
+		})
+	}
+	return config.Encrypt || dataEncrypted
+}
+
+// deriveKey 由口令和盐值派生 AES-256 密钥，调用方需持有 keyMu
+func deriveKey(salt []byte, params kdfParams) ([]byte, error) {
+	if key != nil && bytes.Equal(salt, keySalt) && params == keyParams {
+		return key, nil
+	}
+	k, err := scrypt.Key([]byte(passphrase), salt, params.N, params.R, params.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	keySalt, keyParams, key = salt, params, k
+	return k, nil
+}
+
+func newGCM(k []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptData 用当前口令加密 plain，沿用已缓存的盐值，每次使用新的随机 nonce
+func encryptData(plain []byte) ([]byte, error) {
+	keyMu.Lock()
+	defer keyMu.Unlock()
+	salt := keySalt
+	if salt == nil || keyParams != scryptParams {
+		salt = make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+	}
+	k, err := deriveKey(salt, scryptParams)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(k)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(encryptedFile{
+		Version:   dataVersion,
+		Cipher:    "aes-256-gcm",
+		KDF:       "scrypt",
+		kdfParams: scryptParams,
+		Salt:      salt,
+		Nonce:     nonce,
+		Data:      gcm.Seal(nil, nonce, plain, nil),
+	}, "", "  ")
+}
+
+// decryptData 判断 data 是否为加密的 todo.json，是则用当前口令解密
+// 没有口令或解密失败时记录下来，此后 dataLocked 为真，直到重新设置口令
+func decryptData(data []byte) (plain []byte, encrypted bool, err error) {
+	var f encryptedFile
+	if json.Unmarshal(data, &f) != nil || f.Cipher == "" {
+		return nil, false, nil
+	}
+	keyMu.Lock()
+	defer keyMu.Unlock()
+	dataEncrypted = true
+	if f.Cipher != "aes-256-gcm" || f.KDF != "scrypt" {
+		return nil, true, fmt.Errorf("%w: encryption %s/%s", errUnsupportedData, f.Cipher, f.KDF)
+	}
+	if f.N <= 1 || f.N > maxScryptN || f.N&(f.N-1) != 0 || f.R <= 0 || f.P <= 0 {
+		return nil, true, fmt.Errorf("invalid scrypt parameters n=%d r=%d p=%d", f.N, f.R, f.P)
+	}
+	if passphrase == "" {
+		passphraseRejected = true
+		return nil, true, errNoPassphrase
+	}
+	k, err := deriveKey(f.Salt, f.kdfParams)
+	if err != nil {
+		return nil, true, err
+	}
+	gcm, err := newGCM(k)
+	if err != nil {
+		return nil, true, err
+	}
+	if len(f.Nonce) != gcm.NonceSize() {
+		passphraseRejected = true
+		return nil, true, errWrongPassphrase
+	}
+	plain, err = gcm.Open(nil, f.Nonce, f.Data, nil)
+	if err != nil {
+		// 口令错误时丢弃按该盐值缓存的密钥
+		keySalt, keyParams, key = nil, kdfParams{}, nil
+		passphraseRejected = true
+		return nil, true, errWrongPassphrase
+	}
+	return plain, true, nil
+}
+
+// listFile 返回名为 name 的列表的数据文件路径，空名称对应默认列表 todo.json（或 MYTODO_DATA）
+func listFile(name string) string {
+	if name == "" && dataFileEnv != "" {
+		return dataFileEnv
+	}
+	if name == "" {
+		return filepath.Join(dataDir, "todo.json")
+	}
+	return filepath.Join(dataDir, "todo-"+name+".json")
+}
+
+// listSideFile 返回名为 name 的列表的附属文件路径：默认列表为 <base>.json，其他列表为 <base>-<名称>.json
+func listSideFile(name, base string) string {
+	if name == "" {
+		return filepath.Join(dataDir, base+".json")
+	}
+	return filepath.Join(dataDir, base+"-"+name+".json")
+}
+
+// setListFiles 让回收站、归档和完成记录使用名为 name 的列表的文件
+func setListFiles(name string) {
+	archiveFile = listSideFile(name, "done")
+	trashFile = listSideFile(name, "trash")
+	statsFile = listSideFile(name, "stats")
+}
+
+// validateListName 检查列表名称能否安全地用作文件名的一部分
+func validateListName(name string) error {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\:*?"<>|`) || strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("invalid list name %q", name)
+	}
+	return nil
+}
+
+// listNames 返回数据目录中已有的列表名称（不含默认列表），按名称排序
+func listNames() []string {
+	paths, _ := filepath.Glob(filepath.Join(dataDir, "todo-*.json"))
+	var names []string
+	for _, p := range paths {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(p), "todo-"), ".json")
+		if validateListName(name) == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// watchDataFile 监听数据文件所在的目录，path 返回当前的数据文件，文件有变动时调用 changed
+// 编辑器和同步工具常以重命名方式替换文件，因此监听目录而非文件本身
+// 短时间内的多次事件合并为一次（500ms）；自身的写入由 fileStore.Reload 过滤
+// 切换数据文件后调用返回的 rearm，改为监听新文件所在的目录；监听在 stop 关闭时结束
+func watchDataFile(stop <-chan struct{}, path func() string, changed func()) (rearm func()) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("Warning: cannot watch todo file: %v", err)
+		return func() {}
+	}
+	dir := filepath.Dir(path())
+	if err := watcher.Add(dir); err != nil {
+		log.Printf("Warning: cannot watch todo file: %v", err)
+		watcher.Close()
+		return func() {}
+	}
+	rearmCh := make(chan struct{}, 1)
+	go func() {
+		defer watcher.Close()
+		var debounce *time.Timer
+		for {
+			select {
+			case <-stop:
+				if debounce != nil {
+					debounce.Stop()
+				}
+				return
+			case <-rearmCh:
+				d := filepath.Dir(path())
+				if d == dir {
+					continue
+				}
+				if err := watcher.Add(d); err != nil {
+					log.Printf("Warning: cannot watch todo file: %v", err)
+					continue
+				}
+				_ = watcher.Remove(dir)
+				dir = d
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(ev.Name) != filepath.Clean(path()) || !ev.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+					continue
+				}
+				if debounce != nil {
+					debounce.Stop()
+				}
+				debounce = time.AfterFunc(500*time.Millisecond, changed)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Todo file watcher error: %v", err)
+			}
+		}
+	}()
+	return func() {
+		select {
+		case rearmCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+/* ================= 数据存储 ================= */
+
+// loadArchive 读取已归档的待办，文件不存在时返回空列表
+func loadArchive() ([]Todo, error) {
+	data, err := os.ReadFile(archiveFile)
+	if os.IsNotExist(err) {
+		return []Todo{}, nil
+	}
+	if err == nil {
+		data, err = decodeData(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var archived []Todo
+	if err := json.Unmarshal(data, &archived); err != nil {
+		return nil, err
+	}
+	return archived, nil
+}
+
+func saveArchive(archived []Todo) error {
+	data, err := json.MarshalIndent(archived, "", "  ")
+	if err == nil {
+		data, err = encodeData(data)
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(archiveFile, data, 0600)
+}
+
+// 回收站最多保留的条数和天数
+const (
+	trashLimit   = 20
+	trashMaxDays = 30
+)
+
+// trashedTodo 是回收站中的一条待办及其删除时间
+type trashedTodo struct {
+	Todo
+	DeletedAt time.Time `json:"deleted_at"`
+	// List 为待办原来所在列表的名称，默认列表为空
+	List string `json:"list,omitempty"`
+}
+
+// loadTrash 读取回收站，文件不存在时返回空列表
+func loadTrash() ([]trashedTodo, error) {
+	data, err := os.ReadFile(trashFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err == nil {
+		data, err = decodeData(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var trash []trashedTodo
+	if err := json.Unmarshal(data, &trash); err != nil {
+		return nil, err
+	}
+	return trash, nil
+}
+
+func saveTrash(trash []trashedTodo) error {
+	if trash == nil {
+		trash = []trashedTodo{}
+	}
+	data, err := json.MarshalIndent(trash, "", "  ")
+	if err == nil {
+		data, err = encodeData(data)
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(trashFile, data, 0600)
+}
+
+// pruneTrash 去掉删除超过 trashMaxDays 天的条目，并只保留最近的 trashLimit 条
+// trash 按删除时间从新到旧排列
+func pruneTrash(trash []trashedTodo, now time.Time) []trashedTodo {
+	cutoff := now.AddDate(0, 0, -trashMaxDays)
+	var kept []trashedTodo
+	for _, t := range trash {
+		if t.DeletedAt.After(cutoff) && len(kept) < trashLimit {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+// windowSize 为输入窗口的宽高
+type windowSize struct {
+	Width  float32 `json:"width"`
+	Height float32 `json:"height"`
+}
+
+// windowGeometry 对应 window.json，单行和多行模式分别记录窗口尺寸
+// Fyne 不提供读取或设置窗口位置的接口，因此只保存尺寸，位置由启动时居中决定
+type windowGeometry struct {
+	SingleLine windowSize `json:"single_line"`
+	MultiLine  windowSize `json:"multi_line"`
+}
+
+// loadWindowGeometry 读取 window.json，文件缺失、损坏或尺寸无效时使用默认尺寸
+func loadWindowGeometry() windowGeometry {
+	geom := windowGeometry{
+		SingleLine: windowSize{320, 85},
+		MultiLine:  windowSize{320, 160},
+	}
+	data, err := os.ReadFile(windowFile)
+	if err != nil {
+		return geom
+	}
+	var saved windowGeometry
+	if err := json.Unmarshal(data, &saved); err != nil {
+		log.Printf("Ignoring malformed window file %s: %v", windowFile, err)
+		return geom
+	}
+	if saved.SingleLine.Width > 0 && saved.SingleLine.Height > 0 {
+		geom.SingleLine = saved.SingleLine
+	}
+	if saved.MultiLine.Width > 0 && saved.MultiLine.Height > 0 {
+		geom.MultiLine = saved.MultiLine
+	}
+	return geom
+}
+
+func saveWindowGeometry(geom windowGeometry) error {
+	data, err := json.MarshalIndent(geom, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(windowFile, data, 0600)
+}
+
+// loadDraft 读取 draft.txt 中上次未提交的输入，文件缺失、读取或解密失败时返回空字符串
+func loadDraft() string {
+	data, err := os.ReadFile(draftFile)
+	if err == nil {
+		data, err = decodeData(data)
+	}
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// saveDraft 将未提交的输入写入 draft.txt，内容为空时删除该文件
+// 锁定期间既不写入也不删除，以免解锁前丢掉加密保存的草稿
+func saveDraft(text string) error {
+	if dataLocked() {
+		return errLocked
+	}
+	if text == "" {
+		if err := os.Remove(draftFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := encodeData([]byte(text))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(draftFile, data, 0600)
+}
+
+// statsKeepDays 为 stats.json 中保留的天数，更早的记录在启动时清理
+const statsKeepDays = 366
+
+// completionLog 对应 stats.json，按本地日期（"2006-01-02"）记录当天完成的待办数量
+// 与 todo.json 分开保存，归档或删除待办不影响已记录的数量
+type completionLog struct {
+	Days map[string]int `json:"days"`
+}
+
+// loadCompletions 读取 stats.json，文件不存在时返回空记录
+func loadCompletions() (completionLog, error) {
+	c := completionLog{Days: map[string]int{}}
+	data, err := os.ReadFile(statsFile)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err == nil {
+		data, err = decodeData(data)
+	}
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return completionLog{Days: map[string]int{}}, err
+	}
+	if c.Days == nil {
+		c.Days = map[string]int{}
+	}
+	return c, nil
+}
+
+func saveCompletions(c completionLog) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err == nil {
+		data, err = encodeData(data)
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(statsFile, data, 0600)
+}
+
+// record 把 now 所在日期的完成数量加一
+func (c completionLog) record(now time.Time) {
+	c.Days[now.Format("2006-01-02")]++
+}
+
+// unrecord 把 day 所在日期的完成数量减一，用于取消完成；数量减到 0 时去掉该日期
+func (c completionLog) unrecord(day time.Time) {
+	key := day.Format("2006-01-02")
+	if c.Days[key] <= 1 {
+		delete(c.Days, key)
+		return
+	}
+	c.Days[key]--
+}
+
+// prune 去掉 statsKeepDays 天之前的记录，返回是否有记录被去掉
+func (c completionLog) prune(now time.Time) bool {
+	cutoff := now.AddDate(0, 0, -statsKeepDays).Format("2006-01-02")
+	pruned := false
+	for day := range c.Days {
+		if day < cutoff {
+			delete(c.Days, day)
+			pruned = true
+		}
+	}
+	return pruned
+}
+
+// streak 返回截至 now 连续有完成记录的天数；今天还没有完成时从昨天算起，当天结束前不算中断
+func (c completionLog) streak(now time.Time) int {
+	day := now
+	if c.Days[day.Format("2006-01-02")] == 0 {
+		day = day.AddDate(0, 0, -1)
+	}
+	n := 0
+	for c.Days[day.Format("2006-01-02")] > 0 {
+		n++
+		day = day.AddDate(0, 0, -1)
+	}
+	return n
+}
+
+// backupTodos 将待办写入数据文件 path 旁的 <path>.bak，用于清空前留底
+func backupTodos(path string, todos []Todo) error {
+	data, err := encodeTodos(todos)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+".bak", data, 0600)
+}
+
+// markdownEscaper 转义在 Markdown 中有特殊含义的字符，换行合并为空格以保持一项一行
+var markdownEscaper = strings.NewReplacer(
+	"\\", "\\\\", "`", "\\`", "*", "\\*", "_", "\\_",
+	"[", "\\[", "]", "\\]", "#", "\\#", "<", "\\<", ">", "\\>",
+	"|", "\\|", "~", "\\~", "\r\n", " ", "\n", " ",
+)
+
+// exportMarkdown 将待办写为 GitHub 任务列表格式的 Markdown 文件
+func exportMarkdown(todos []Todo, path string) error {
+	var b strings.Builder
+	for _, t := range todos {
+		check := " "
+		if t.Done {
+			check = "x"
+		}
+		fmt.Fprintf(&b, "- [%s] %s\n", check, markdownEscaper.Replace(t.Text))
+	}
+	return os.WriteFile(path, []byte(b.String()), 0600)
+}
+
+// icsEscaper 按 RFC 5545 转义 TEXT 类型属性值中的特殊字符
+var icsEscaper = strings.NewReplacer(
+	"\\", "\\\\", ";", "\\;", ",", "\\,", "\r\n", "\\n", "\n", "\\n",
+)
+
+// icsTime 将时间格式化为 iCalendar 的 UTC 日期时间
+func icsTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// writeICSLine 写入一行内容，超过 75 字节时按 RFC 5545 折行（续行以空格开头），
+// 不会从 UTF-8 字符中间断开，行尾为 CRLF
+func writeICSLine(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// 续行开头的空格占用一个字节
+		limit = 74
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+// exportICS 将有截止时间的待办写为 iCalendar 文件中的 VTODO，没有截止时间的跳过
+// UID 使用待办 ID，DTSTAMP 使用创建时间，返回实际导出的数量
+func exportICS(todos []Todo, path string) (int, error) {
+	var b strings.Builder
+	writeICSLine(&b, "BEGIN:VCALENDAR")
+	writeICSLine(&b, "VERSION:2.0")
+	writeICSLine(&b, "PRODID:-//mytodo//"+appID+"//ZH")
+	n := 0
+	for _, t := range todos {
+		if t.Due == nil {
+			continue
+		}
+		stamp := t.Created
+		if stamp.IsZero() {
+			stamp = time.Now()
+		}
+		writeICSLine(&b, "BEGIN:VTODO")
+		writeICSLine(&b, "UID:"+t.ID+"@mytodo")
+		writeICSLine(&b, "DTSTAMP:"+icsTime(stamp))
+		writeICSLine(&b, "DUE:"+icsTime(*t.Due))
+		writeICSLine(&b, "SUMMARY:"+icsEscaper.Replace(t.Text))
+		if t.Done {
+			writeICSLine(&b, "STATUS:COMPLETED")
+		} else {
+			writeICSLine(&b, "STATUS:NEEDS-ACTION")
+		}
+		writeICSLine(&b, "END:VTODO")
+		n++
+	}
+	writeICSLine(&b, "END:VCALENDAR")
+	return n, os.WriteFile(path, []byte(b.String()), 0600)
+}
+
+// plainText 把待办逐行拼接为纯文本，已完成的加上 "[x] " 前缀，用于复制到剪贴板
+func plainText(todos []Todo) string {
+	lines := make([]string, len(todos))
+	for i, t := range todos {
+		lines[i] = t.Text
+		if t.Done {
+			lines[i] = "[x] " + t.Text
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// importFromFile 读取文本文件，每个非空行（去除首尾空白）作为一条待办
+// 与手动输入一样按权重截断
+func importFromFile(path string) ([]Todo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var todos []Todo
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		text := truncateByWeight(line, config.inputLimit(), config.WeightMode)
+		todos = append(todos, Todo{ID: newID(), Text: text, Created: time.Now(), Tags: extractTags(text)})
+	}
+	return todos, nil
+}
+
+// trayIconSVG 为内嵌的默认托盘图标，矢量图在各种缩放比例下都保持清晰
+//
+//go:embed tray.svg
+var trayIconSVG []byte
+
+// checkSVG 检查 data 是否为根元素为 <svg> 的 XML 文档
+func checkSVG(data []byte) error {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if el, ok := tok.(xml.StartElement); ok {
+			if el.Name.Local != "svg" {
+				return fmt.Errorf("root element is <%s>", el.Name.Local)
+			}
+			return nil
+		}
+	}
+}
+
+// checkIconImage 检查 data 能否作为托盘图标：SVG 或可解码的 PNG/JPEG 图片
+func checkIconImage(data []byte) error {
+	if checkSVG(data) == nil {
+		return nil
+	}
+	_, _, err := image.DecodeConfig(bytes.NewReader(data))
+	return err
+}
+
+// iconColor 返回托盘图标线条的颜色：深色主题下为浅色，否则为黑色
+func iconColor(dark bool) color.RGBA {
+	if dark {
+		return color.RGBA{230, 230, 230, 255}
+	}
+	return color.RGBA{0, 0, 0, 255}
+}
+
+// loadTrayIcon 按优先级选择托盘图标：配置中的 icon 文件、内嵌 SVG、生成的 PNG
+// 内嵌和生成的图标按 dark 使用对应的线条颜色，自定义图标保持原样
+// 无法读取或解码的图标会被跳过，最终选中的来源记录到日志
+// badgeBase 为绘制未完成数量角标时使用的底图；自定义 SVG 图标无法栅格化，此时为 nil，不显示角标
+func loadTrayIcon(dark bool) (res fyne.Resource, badgeBase image.Image) {
+	if config.Icon != "" {
+		data, err := os.ReadFile(config.Icon)
+		if err == nil {
+			err = checkIconImage(data)
+		}
+		if err == nil {
+			log.Printf("Using custom tray icon %s", config.Icon)
+			res = fyne.NewStaticResource(filepath.Base(config.Icon), data)
+			if img, _, err := image.Decode(bytes.NewReader(data)); err == nil {
+				badgeBase = img
+			}
+			return res, badgeBase
+		}
+		log.Printf("Ignoring custom tray icon %s: %v", config.Icon, err)
+	}
+	badgeBase = baseIconImage(iconColor(dark))
+	err := checkSVG(trayIconSVG)
+	if err == nil {
+		if dark {
+			c := iconColor(true)
+			fill := fmt.Sprintf(`fill="#%02x%02x%02x"`, c.R, c.G, c.B)
+			log.Println("Using embedded tray icon (dark)")
+			return fyne.NewStaticResource("tray-dark.svg", bytes.ReplaceAll(trayIconSVG, []byte(`fill="#000"`), []byte(fill))), badgeBase
+		}
+		log.Println("Using embedded tray icon")
+		return fyne.NewStaticResource("tray.svg", trayIconSVG), badgeBase
+	}
+	log.Printf("Embedded tray icon is not valid SVG: %v", err)
+	iconPath := ensureIcon(dark)
+	if iconPath == "" {
+		return nil, nil
+	}
+	res, err = fyne.LoadResourceFromPath(iconPath)
+	if err != nil {
+		log.Printf("Failed to load tray icon %s: %v", iconPath, err)
+		return nil, nil
+	}
+	log.Printf("Using generated tray icon %s", iconPath)
+	return res, badgeBase
+}
+
+// ensureIcon 确保缓存目录中有生成的 PNG 图标并返回其路径，仅在内嵌 SVG 不可用时使用
+// 深色主题的图标保存为 tray-dark.png，已有文件无法解码时重新生成
+func ensureIcon(dark bool) string {
+	iconFile := iconFile
+	if dark {
+		iconFile = strings.TrimSuffix(iconFile, ".png") + "-dark.png"
+	}
+	if data, err := os.ReadFile(iconFile); err == nil {
+		if _, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+			// 文件已存在且有效，返回绝对路径
+			abs, _ := filepath.Abs(iconFile)
+			return abs
+		}
+		log.Printf("Regenerating invalid icon file %s", iconFile)
+	}
+	// 文件不存在或已损坏，创建它
+	img := baseIconImage(iconColor(dark))
+	f, err := os.Create(iconFile)
+	if err != nil {
+		log.Printf("Failed to create icon file: %v", err)
+		return "" // 返回空字符串，Fyne可能会使用默认图标
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		log.Printf("Failed to encode icon: %v", err)
+	}
+	abs, _ := filepath.Abs(iconFile)
+	return abs
+}
+
+// baseIconImage 绘制 32x32 的三横线托盘图标
+func baseIconImage(fg color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{0, 0, 0, 0}}, image.Point{}, draw.Src)
+	for y := 8; y <= 22; y += 7 {
+		for x := 8; x <= 22; x++ {
+			img.Set(x, y, fg)
+		}
+	}
+	return img
+}
+
+// renderBadgeIcon 把 base 缩放为 32x32 后在右下角绘制红色圆形角标，显示未完成数量，超过 9 显示 "9+"
+func renderBadgeIcon(count int, base image.Image) fyne.Resource {
+	img := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	xdraw.CatmullRom.Scale(img, img.Bounds(), base, base.Bounds(), draw.Over, nil)
+	label := strconv.Itoa(count)
+	if count > 9 {
+		label = "9+"
+	}
+	face := basicfont.Face7x13
+	textW := font.MeasureString(face, label).Ceil()
+	// 角标宽度随文字变化，高度固定 14 像素，靠右下角放置
+	w := max(textW+4, 14)
+	badge := image.Rect(32-w, 18, 32, 32)
+	red := color.RGBA{220, 40, 40, 255}
+	cx2, cy2 := badge.Min.X*2+w, badge.Min.Y*2+14
+	rx, ry := w, 14
+	for y := badge.Min.Y; y < badge.Max.Y; y++ {
+		for x := badge.Min.X; x < badge.Max.X; x++ {
+			// 以二倍坐标判断像素中心是否落在椭圆内
+			dx, dy := x*2+1-cx2, y*2+1-cy2
+			if dx*dx*ry*ry+dy*dy*rx*rx <= rx*rx*ry*ry {
+				img.Set(x, y, red)
+			}
+		}
+	}
+	d := font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(color.White),
+		Face: face,
+		Dot:  fixed.P(badge.Min.X+(w-textW)/2, badge.Max.Y-3),
+	}
+	d.DrawString(label)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		log.Printf("Failed to encode badge icon: %v", err)
+		return nil
+	}
+	return fyne.NewStaticResource(fmt.Sprintf("icon-badge-%s.png", label), buf.Bytes())
+}
+
+// variantTheme 以固定的明暗变体使用 base 主题，用于配置 theme 为 light 或 dark 时
+type variantTheme struct {
+	fyne.Theme
+	variant fyne.ThemeVariant
+}
+
+func (t variantTheme) Color(name fyne.ThemeColorName, _ fyne.ThemeVariant) color.Color {
+	return t.Theme.Color(name, t.variant)
+}
+
+// getExecutableDir 返回可执行文件所在的目录
+func getExecutableDir() (string, error) {
+	exePath, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Dir(exePath), nil
+}
+
+// xdgDir 返回 XDG 基础目录 envVar 下的 mytodo 子目录并确保其存在
+// 环境变量未设置或不是绝对路径时（规范要求忽略相对路径），使用主目录下的 fallback
+func xdgDir(envVar, fallback string) (string, error) {
+	base := os.Getenv(envVar)
+	if base == "" || !filepath.IsAbs(base) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(home, fallback)
+	}
+	dir := filepath.Join(base, "mytodo")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// initPaths 按 XDG Base Directory 规范初始化数据、配置和缓存目录
+// 无法解析主目录或创建目录时，退回到可执行文件所在目录
+func initPaths() {
+	exeDir, err := getExecutableDir()
+	if err != nil {
+		// 如果获取失败，使用当前目录作为备选
+		log.Printf("Warning: could not get executable directory: %v. Using current directory.", err)
+		exeDir, _ = os.Getwd()
+	}
+	resolve := func(envVar, fallback string) string {
+		dir, err := xdgDir(envVar, fallback)
+		if err != nil {
+			log.Printf("Warning: could not resolve %s: %v. Using executable directory.", envVar, err)
+			return exeDir
+		}
+		return dir
+	}
+	dataDir = resolve("XDG_DATA_HOME", ".local/share")
+	configDir = resolve("XDG_CONFIG_HOME", ".config")
+	cacheDir = resolve("XDG_CACHE_HOME", ".cache")
+
+	dataFile = filepath.Join(dataDir, "todo.json")
+	archiveFile = filepath.Join(dataDir, "done.json")
+	trashFile = filepath.Join(dataDir, "trash.json")
+	iconFile = filepath.Join(cacheDir, "tray.png")
+	configFile = filepath.Join(configDir, "config.json")
+	windowFile = filepath.Join(configDir, "window.json")
+	draftFile = filepath.Join(dataDir, "draft.txt")
+	statsFile = filepath.Join(dataDir, "stats.json")
+
+	// 数据文件的优先级：环境变量 MYTODO_DATA > 配置中的 list（见 main）> 数据目录下的 todo.json
+	// MYTODO_DATA 只替换默认列表的数据文件，回收站、归档等其他文件仍在数据目录中
+	if p := os.Getenv("MYTODO_DATA"); p != "" {
+		abs, err := filepath.Abs(p)
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(abs), 0700)
+		}
+		if err != nil {
+			log.Printf("Warning: ignoring MYTODO_DATA %q: %v", p, err)
+		} else {
+			dataFileEnv = abs
+			dataFile = abs
+			return
+		}
+	}
+
+	// 旧版本把数据放在可执行文件旁边，首次启动时复制过来
+	legacy := filepath.Join(exeDir, "todo.json")
+	if legacy != dataFile {
+		if _, err := os.Stat(dataFile); os.IsNotExist(err) {
+			if data, err := os.ReadFile(legacy); err == nil {
+				if err := os.WriteFile(dataFile, data, 0600); err != nil {
+					log.Printf("Error migrating legacy todo file: %v", err)
+				} else {
+					log.Printf("Copied legacy todo file %s to %s", legacy, dataFile)
+				}
+			}
+		}
+	}
+}
+
+/* ================= 开机启动 ================= */
+
+// autostartFile 返回 XDG 自启动目录下本程序的 .desktop 文件路径
+func autostartFile() (string, error) {
+	base := os.Getenv("XDG_CONFIG_HOME")
+	if base == "" || !filepath.IsAbs(base) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(home, ".config")
+	}
+	return filepath.Join(base, "autostart", appID+".desktop"), nil
+}
+
+// autostartEnabled 判断是否已设置开机启动
+func autostartEnabled() bool {
+	path, err := autostartFile()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// desktopExecQuote 按 Desktop Entry 规范为 Exec 中的参数加引号并转义
+// 引号内的 " ` $ \ 需要加反斜杠，而整个值先经过字符串转义，反斜杠本身还要再写一次；% 写作 %% 以免被当成字段代码
+func desktopExecQuote(arg string) string {
+	return `"` + strings.NewReplacer(`\`, `\\\\`, `"`, `\\"`, "`", "\\\\`", `$`, `\\$`, `%`, `%%`).Replace(arg) + `"`
+}
+
+// setAutostart 写入或删除自启动 .desktop 文件，Exec 指向当前可执行文件
+func setAutostart(enabled bool) error {
+	path, err := autostartFile()
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	entry := "[Desktop Entry]\n" +
+		"Type=Application\n" +
+		"Name=MyTodo\n" +
+		"Exec=" + desktopExecQuote(exe) + "\n" +
+		"X-GNOME-Autostart-enabled=true\n"
+	return os.WriteFile(path, []byte(entry), 0600)
+}
+
+/* ================= 日志 ================= */
+
+// verbose 由 -v 开启，为 true 时输出调试日志
+var verbose bool
+
+// debugf 仅在 verbose 开启时记录日志
+func debugf(format string, args ...any) {
+	if verbose {
+		log.Printf(format, args...)
+	}
+}
+
+// maxLogSize 日志文件超过该大小（约 1MB）时改名为 .old 并重新开始
+const maxLogSize = 1 << 20
+
+// rotatingLog 是按大小轮转的日志文件，只保留一份 .old
+type rotatingLog struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	size int64
+}
+
+func openRotatingLog(path string) (*rotatingLog, error) {
+	l := &rotatingLog{path: path}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *rotatingLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, info.Size()
+	return nil
+}
+
+func (l *rotatingLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.size > 0 && l.size+int64(len(p)) > maxLogSize {
+		l.f.Close()
+		// 改名失败时继续写原文件，总比丢日志好
+		_ = os.Rename(l.path, l.path+".old")
+		if err := l.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// isTerminal 判断 f 是否连接到终端
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// setupLogging 按配置将日志写入文件；从终端启动时同时保留 stderr 输出
+func setupLogging() {
+	if config.LogFile == "" {
+		return
+	}
+	l, err := openRotatingLog(config.LogFile)
+	if err != nil {
+		log.Printf("Error opening log file, logging to stderr only: %v", err)
+		return
+	}
+	if isTerminal(os.Stderr) {
+		log.SetOutput(io.MultiWriter(os.Stderr, l))
+	} else {
+		log.SetOutput(l)
+	}
+}
+
+/* ================= HTTP 服务 ================= */
+
+// serveAddr 补全 -serve 的监听地址，未指定主机（如 ":8080"）时只监听本机
+func serveAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != "" {
+		return addr
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+// allowedHost 判断请求的 Host 头是否指向本机的 port 端口
+// 只接受 127.0.0.1、localhost 和 [::1]，用于防御 DNS 重绑定：恶意网页把自己的域名解析到 127.0.0.1 后，
+// 浏览器发出的请求仍带着该域名作为 Host
+func allowedHost(host, port string) bool {
+	switch host {
+	case net.JoinHostPort("127.0.0.1", port), net.JoinHostPort("localhost", port), net.JoinHostPort("::1", port):
+		return true
+	}
+	return false
+}
+
+// newHTTPHandler 返回监听 port 端口的 HTTP 接口：GET /todos 以 JSON 返回全部待办，
+// POST /todos 从 {"text": "..."} 新增一条待办，与输入窗口共用同一个 Store
+// 接口只供本机脚本使用：带 Origin 头的（浏览器发起的）请求和 Host 不是本机地址的请求一律拒绝，
+// POST 必须使用 application/json，使网页无法在不经 CORS 预检的情况下提交表单或纯文本
+func newHTTPHandler(port string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/todos", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Origin") != "" {
+			http.Error(w, "cross-origin requests are not allowed", http.StatusForbidden)
+			return
+		}
+		if !allowedHost(r.Host, port) {
+			http.Error(w, "invalid Host header", http.StatusForbidden)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(store.All()); err != nil {
+				log.Printf("Failed to write todo list: %v", err)
+			}
+		case http.MethodPost:
+			if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+				http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+				return
+			}
+			var req struct {
+				Text string `json:"text"`
+			}
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+				http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			text := strings.TrimSpace(req.Text)
+			if text == "" {
+				http.Error(w, "text must not be empty", http.StatusBadRequest)
+				return
+			}
+			err := fmt.Errorf("instance is still starting")
+			fyne.DoAndWait(func() {
+				if addTodo != nil {
+					err = addTodo(text)
+				}
+			})
+			switch {
+			case errors.Is(err, errDuplicate), errors.Is(err, errTodoLimit):
+				http.Error(w, err.Error(), http.StatusConflict)
+			case errors.Is(err, errEmptyTodo):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case err != nil:
+				log.Printf("HTTP add failed: %v", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			default:
+				w.WriteHeader(http.StatusCreated)
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	return mux
+}
+
+// serveHTTP 在 addr 上提供 HTTP 接口，直到 stop 关闭时停止
+func serveHTTP(addr string, stop <-chan struct{}) {
+	addr = serveAddr(addr)
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		log.Printf("Invalid HTTP address %q: %v", addr, err)
+		return
+	}
+	srv := &http.Server{Addr: addr, Handler: newHTTPHandler(port), ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-stop
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down HTTP server: %v", err)
+		}
+	}()
+	log.Printf("HTTP server listening on %s", srv.Addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("HTTP server failed: %v", err)
+	}
+}
+
+/* ================= 单实例逻辑 ================= */
+
+// instanceIPC 抽象单实例检测和命令传递所用的本地通信端点
+// 各平台的实现见 ipc_unix.go 和 ipc_windows.go
+type instanceIPC interface {
+	// Dial 连接到已在运行的主实例
+	Dial() (net.Conn, error)
+	// Listen 作为主实例开始监听
+	Listen() (net.Listener, error)
+	// Cleanup 在退出时清理端点残留（如 socket 文件）
+	Cleanup()
+	// Address 返回端点地址，用于日志
+	Address() string
+}
+
+// parseCommand 将命令行参数解析为发送给主实例的一行消息
+// 无参数时为 "show"；"add <文本>" 会把其余参数以空格连接为待办文本；"list" 列出待办；
+// "done <n>" 切换第 n 条（从 1 开始，与 list 的顺序一致）的完成状态；"remove <n>" 删除第 n 条
+func parseCommand(args []string) (string, error) {
+	if len(args) == 0 {
+		return "show", nil
+	}
+	switch args[0] {
+	case "add":
+		// 消息按行分隔，文本中的换行替换为空格
+		text := strings.Join(args[1:], " ")
+		text = strings.TrimSpace(strings.NewReplacer("\r", " ", "\n", " ").Replace(text))
+		if text == "" {
+			return "", fmt.Errorf("usage: todo add <text>")
+		}
+		return "add " + text, nil
+	case "list":
+		return "list", nil
+	case "version":
+		return "version", nil
+	case "done":
+		if len(args) != 2 {
+			return "", fmt.Errorf("usage: todo done <n>")
+		}
+		if _, err := strconv.Atoi(args[1]); err != nil {
+			return "", fmt.Errorf("invalid index %q", args[1])
+		}
+		return "done " + args[1], nil
+	case "remove":
+		if len(args) != 2 {
+			return "", fmt.Errorf("usage: todo remove <n>")
+		}
+		if _, err := strconv.Atoi(args[1]); err != nil {
+			return "", fmt.Errorf("invalid index %q", args[1])
+		}
+		return "remove " + args[1], nil
+	default:
+		return "", fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// runSingleInstanceCheck 检查是否已有实例在运行
+// 如果是，则发送 message 并返回 nil。如果不是，则开始监听并返回 listener，
+// 此时当前进程是主实例，调用方在 Store 就绪后用 serveInstances 处理连接，在此之前到达的连接会排队等待
+func runSingleInstanceCheck(message string) (net.Listener, error) {
+	// 尝试连接到已存在的实例
+	conn, err := ipc.Dial()
+	if err == nil {
+		// 连接成功，说明已有实例在运行；它可能已经卡死，收发都限定在 ipcTimeout 内
+		err = sendToInstance(conn, message)
+		conn.Close()
+		if !isTimeout(err) {
+			return nil, err // nil 表示不是主实例
+		}
+		log.Printf("Existing instance at %s is not responding: %v", ipc.Address(), err)
+	}
+
+	// 连接失败或已有实例无响应，当前进程成为主实例
+	// 若是上次异常退出残留的端点（连接被拒绝）或无响应的实例，先清理掉再监听
+	if isStaleEndpoint(err) || isTimeout(err) {
+		log.Printf("Removing stale socket %s: %v", ipc.Address(), err)
+		ipc.Cleanup()
+	}
+	listener, err := ipc.Listen()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create socket listener: %w", err)
+	}
+	log.Printf("Socket listener started at %s", ipc.Address())
+	return listener, nil
+}
+
+// serveInstances 接受新实例和命令行发来的连接，每个连接在单独的 goroutine 中处理
+func serveInstances(listener net.Listener) {
+	defer listener.Close()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Printf("Socket accept error: %v", err)
+			continue
+		}
+		go handleSocketConnection(conn)
+	}
+}
+
+// sendToInstance 向已有实例发送 message 并读取其回复
+// 主实例对每条命令回复一行 "ok" 或错误信息；旧版本不回复，读不到时不视为失败
+func sendToInstance(conn net.Conn, message string) error {
+	_ = conn.SetDeadline(time.Now().Add(ipcTimeout))
+	// 发送命令，默认为 "show" 信号
+	if _, err := conn.Write([]byte(message + "\n")); err != nil {
+		return fmt.Errorf("failed to send signal to existing instance: %w", err)
+	}
+	log.Printf("Another instance is already running. Sent %q to it.", message)
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if isTimeout(err) {
+		return err
+	}
+	if err != nil {
+		log.Printf("No reply from existing instance: %v", err)
+		return nil
+	}
+	if reply = strings.TrimSpace(reply); reply != "ok" {
+		return fmt.Errorf("existing instance replied: %s", reply)
+	}
+	return nil
+}
+
+// isTimeout 判断 err 是否为连接或读写超时
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// handleSocketConnection 处理来自新实例的连接
+func handleSocketConnection(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	// 读取一行消息
+	message, err := reader.ReadString('\n')
+	if err != nil {
+		log.Printf("Failed to read from socket: %v", err)
+		return
+	}
+
+	message = strings.TrimSpace(message)
+	debugf("Received signal from new instance: %s", message)
+
+	switch {
+	case message == "config:get":
+		var data []byte
+		fyne.DoAndWait(func() {
+			data, err = json.Marshal(config)
+		})
+		if err != nil {
+			replyError(conn, err)
+			return
+		}
+		_, _ = conn.Write(append(data, '\n'))
+	case strings.HasPrefix(message, "config:set "):
+		// 未给出的字段保持当前值；合并、保存和替换都在主 goroutine 中进行，避免与 UI 回调竞争
+		fyne.DoAndWait(func() {
+			var cfg Config
+			if cfg, err = mergeConfig(config, []byte(strings.TrimPrefix(message, "config:set "))); err != nil {
+				return
+			}
+			if err = saveConfig(cfg); err != nil {
+				return
+			}
+			config = cfg
+			if applyConfig != nil {
+				applyConfig()
+			}
+		})
+		if err != nil {
+			replyError(conn, err)
+			return
+		}
+		_, _ = conn.Write([]byte("ok\n"))
+	case strings.HasPrefix(message, "add "):
+		text := strings.TrimSpace(strings.TrimPrefix(message, "add "))
+		err = fmt.Errorf("instance is still starting")
+		fyne.DoAndWait(func() {
+			if addTodo != nil {
+				err = addTodo(text)
+			}
+		})
+		if err != nil {
+			replyError(conn, err)
+			return
+		}
+		_, _ = conn.Write([]byte("ok\n"))
+	case strings.HasPrefix(message, "done "):
+		n, err := strconv.Atoi(strings.TrimPrefix(message, "done "))
+		if err != nil {
+			replyError(conn, fmt.Errorf("invalid index %q", strings.TrimPrefix(message, "done ")))
+			return
+		}
+		err = fmt.Errorf("instance is still starting")
+		fyne.DoAndWait(func() {
+			if toggleDoneAt != nil {
+				err = toggleDoneAt(n)
+			}
+		})
+		if err != nil {
+			replyError(conn, err)
+			return
+		}
+		_, _ = conn.Write([]byte("ok\n"))
+	case strings.HasPrefix(message, "remove "):
+		n, err := strconv.Atoi(strings.TrimPrefix(message, "remove "))
+		if err != nil {
+			replyError(conn, fmt.Errorf("invalid index %q", strings.TrimPrefix(message, "remove ")))
+			return
+		}
+		err = fmt.Errorf("instance is still starting")
+		fyne.DoAndWait(func() {
+			if removeAt != nil {
+				err = removeAt(n)
+			}
+		})
+		if err != nil {
+			replyError(conn, err)
+			return
+		}
+		_, _ = conn.Write([]byte("ok\n"))
+	case message == "list":
+		if dataLocked() {
+			replyError(conn, errLocked)
+			return
+		}
+		if err := writeTodoLines(conn, store.All()); err != nil {
+			log.Printf("Failed to write todo list: %v", err)
+		}
+	case message == "version":
+		_, _ = fmt.Fprintln(conn, version)
+	case message == "show":
+		// 使用 fyne.Do 确保在主 goroutine 中执行 UI 操作
+		fyne.Do(func() {
+			// 假设 inputWin 是一个包级变量或可以通过闭包访问
+			// 在我们的代码结构中，需要将 inputWin 提升或通过其他方式访问
+			// 这里我们通过一个技巧：在 main 函数中定义一个 showWindow 函数
+			if showWindow != nil {
+				showWindow()
+			}
+		})
+		_, _ = conn.Write([]byte("ok\n"))
+	default:
+		replyError(conn, fmt.Errorf("unknown command %q", message))
+	}
+}
+
+// writeTodoLines 将待办逐条编码为 JSON，每行一条
+func writeTodoLines(w io.Writer, todos []Todo) error {
+	enc := json.NewEncoder(w)
+	for _, t := range todos {
+		if err := enc.Encode(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runListCommand 向主实例查询待办并打印到标准输出
+// 没有实例在运行时直接读取 todo.json；数据文件无法读取或解密时返回错误，而不是打印空列表
+func runListCommand() error {
+	conn, err := ipc.Dial()
+	if err != nil {
+		todos, err := loadTodos()
+		if err != nil {
+			return err
+		}
+		return writeTodoLines(os.Stdout, todos)
+	}
+	defer conn.Close()
+	// 主实例卡死时不会一直等待
+	_ = conn.SetDeadline(time.Now().Add(ipcTimeout))
+	if _, err := conn.Write([]byte("list\n")); err != nil {
+		return fmt.Errorf("failed to send list command: %w", err)
+	}
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if msg, ok := strings.CutPrefix(line, "error: "); ok {
+			return errors.New(strings.TrimSpace(msg))
+		}
+		if _, werr := io.WriteString(os.Stdout, line); werr != nil {
+			return werr
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// errNoInstance 表示没有正在运行的主实例
+var errNoInstance = errors.New("no running instance")
+
+// runRemoteCommand 把 message 发送给主实例，并打印其回复的一行结果
+// 没有实例在运行时返回包装了 errNoInstance 的错误，回复不是 "ok" 时返回错误
+func runRemoteCommand(message string) error {
+	conn, err := ipc.Dial()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errNoInstance, err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(ipcTimeout))
+	if _, err := conn.Write([]byte(message + "\n")); err != nil {
+		return fmt.Errorf("failed to send command: %w", err)
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read reply: %w", err)
+	}
+	reply = strings.TrimSpace(reply)
+	if reply != "ok" {
+		return errors.New(reply)
+	}
+	fmt.Println(reply)
+	return nil
+}
+
+// removeFromFile 在没有实例运行时直接从数据文件删除第 n 条（从 1 开始）待办，并放入回收站
+func removeFromFile(n int) error {
+	todos, err := loadTodos()
+	if err != nil {
+		return err
+	}
+	if n < 1 || n > len(todos) {
+		return fmt.Errorf("index %d out of range (1-%d)", n, len(todos))
+	}
+	t := todos[n-1]
+	todos = append(todos[:n-1:n-1], todos[n:]...)
+	if err := saveTodos(todos); err != nil {
+		return err
+	}
+	trash, err := loadTrash()
+	if err != nil {
+		log.Printf("Error loading trash: %v", err)
+	}
+	trash = pruneTrash(append([]trashedTodo{{Todo: t, DeletedAt: time.Now(), List: activeList}}, trash...), time.Now())
+	if err := saveTrash(trash); err != nil {
+		log.Printf("Error saving trash: %v", err)
+	}
+	fmt.Println("ok")
+	return nil
+}
+
+// runVersionCommand 打印本程序的版本，若有实例正在运行，同时打印其版本
+func runVersionCommand() {
+	fmt.Println(version)
+	conn, err := ipc.Dial()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(ipcTimeout))
+	if _, err := conn.Write([]byte("version\n")); err != nil {
+		return
+	}
+	if reply, err := bufio.NewReader(conn).ReadString('\n'); err == nil {
+		fmt.Printf("running instance: %s\n", strings.TrimSpace(reply))
+	}
+}
+
+// replyError 向 socket 客户端返回一行错误信息
+func replyError(conn net.Conn, err error) {
+	log.Printf("Socket command failed: %v", err)
+	_, _ = fmt.Fprintf(conn, "error: %v\n", err)
+}
+
+/* ================= Main ================= */
+
+// showWindow 是一个函数变量，用于在 socket 信号到达时调用
+var showWindow func()
+
+// addTodo 是一个函数变量，用于在收到 add 命令时新增待办
+var addTodo func(text string) error
+
+// toggleDoneAt 是一个函数变量，用于在收到 done 命令时切换第 n 条（从 1 开始）的完成状态
+var toggleDoneAt func(n int) error
+
+// removeAt 是一个函数变量，用于在收到 remove 命令时删除第 n 条（从 1 开始）待办
+var removeAt func(n int) error
+
+// applyConfig 在运行时配置被替换后调用，刷新依赖配置的界面
+var applyConfig func()
+
+func main() {
+	flag.BoolVar(&verbose, "v", false, "输出调试日志")
+	showVersion := flag.Bool("version", false, "打印版本并退出")
+	serve := flag.String("serve", "", "同时在该地址（如 :8080）提供 HTTP 接口，未指定主机时只监听本机")
+	flag.Parse()
+	if *showVersion {
+		fmt.Println(version)
+		return
+	}
+
+	// 1. 初始化路径
+	initPaths()
+	config = loadConfig()
+
+	// 设置了 MYTODO_DATA 时忽略配置中的 list
+	if config.List != "" && dataFileEnv == "" {
+		activeList = config.List
+		dataFile = listFile(config.List)
+		setListFiles(config.List)
+	}
+
+	// 设置单实例通信端点，具体地址由平台实现决定
+	ipc = newInstanceIPC()
+
+	// 加密口令只从环境变量读取；主实例缺少口令时在启动后询问
+	setPassphrase(os.Getenv("MYTODO_PASSPHRASE"))
+
+	// 2. 解析命令行并进行单实例检查
+	message, err := parseCommand(flag.Args())
+	if err != nil {
+		log.Fatal(err)
+	}
+	// list、done、remove 只输出结果，不会成为主实例
+	if message == "list" {
+		if err := runListCommand(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if message == "version" {
+		runVersionCommand()
+		return
+	}
+	if strings.HasPrefix(message, "done ") {
+		if err := runRemoteCommand(message); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if rest, ok := strings.CutPrefix(message, "remove "); ok {
+		err := runRemoteCommand(message)
+		if errors.Is(err, errNoInstance) {
+			n, _ := strconv.Atoi(rest)
+			err = removeFromFile(n)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	listener, err := runSingleInstanceCheck(message)
+	if err != nil {
+		log.Fatalf("Single instance check failed: %v", err)
+	}
+	if listener == nil {
+		// 如果不是主实例，直接退出
+		os.Exit(0)
+	}
+
+	// --- 以下是主实例的逻辑 ---
+
+	setupLogging()
+
+	a := app.NewWithID(appID)
+	files := newFileStore(dataFile, fileCodec{}, func() int { return config.Backups })
+	if err := files.Load(); errors.Is(err, errUnsupportedData) {
+		// 以空列表启动会在下次保存时覆盖新版本的数据
+		ipc.Cleanup()
+		log.Fatalf("Refusing to start: %s: %v", files.Path(), err)
+	} else if err != nil && !errors.Is(err, errLocked) {
+		log.Printf("Error loading todos: %v", err)
+	}
+	store = files
+	// Store 就绪后才处理 socket 命令，之前到达的连接在监听队列中等待
+	go serveInstances(listener)
+
+	// 将窗口和托盘相关变量定义在 main 作用域内
+	var inputWin fyne.Window
+	var tray desktop.App
+	var rebuildTray func()
+	// trayIcon 为图标文件对应的资源，没有未完成待办时显示它
+	var trayIcon fyne.Resource
+	// trayBadgeBase 为 trayIcon 对应的角标底图，为 nil 时不显示角标
+	var trayBadgeBase image.Image
+	// iconDark 为当前图标是否按深色主题绘制
+	iconDark := false
+
+	// 以下两个变量只在主 goroutine 中访问，用于空闲自动退出
+	lastActivity := time.Now()
+	windowVisible := false
+
+	// 定义 showWindow 函数，使其可以被 socket 处理器调用
+	showWindow = func() {
+		if inputWin != nil {
+			inputWin.Show()
+			inputWin.RequestFocus()
+			windowVisible = true
+			lastActivity = time.Now()
+		}
+	}
+
+	inputWin = a.NewWindow("新增待办")
+	entry := newTodoEntry()
+	entry.SetPlaceHolder("输入待办事项...")
+
+	leftTips := canvas.NewText(inputTips("", config.inputLimit(), config.WeightMode), color.NRGBA{128, 128, 128, 255})
+	leftTips.TextSize = 10
+
+	// defaultTip 返回当前输入模式下右下角的默认提示
+	defaultTip := func() string {
+		if config.MultiLine {
+			return "Shift+回车提交"
+		}
+		return "按回车提交"
+	}
+
+	rightTips := canvas.NewText(defaultTip(), color.NRGBA{150, 150, 150, 200})
+	rightTips.TextSize = 10
+	rightTips.Alignment = fyne.TextAlignTrailing
+
+	// flashTip 在右下角短暂显示一条提示，d 之后恢复默认文字
+	// tipGen 在每次显示提示时递增，只有最新一次提示的计时结束才恢复默认提示，
+	// 避免连续提交时较早的计时把较新的提示提前清掉
+	tipGen := 0
+	flashTip := func(text string, c color.Color, d time.Duration) {
+		tipGen++
+		gen := tipGen
+		rightTips.Text = text
+		rightTips.Color = c
+		rightTips.Refresh()
+		go func() {
+			time.Sleep(d)
+			fyne.Do(func() {
+				if gen != tipGen {
+					return
+				}
+				rightTips.Text = defaultTip()
+				rightTips.Color = color.NRGBA{150, 150, 150, 200}
+				rightTips.Refresh()
+			})
+		}()
+	}
+
+	showSuccess := func() {
+		flashTip(config.successMessage(), color.NRGBA{50, 205, 50, 255}, config.successDuration())
+	}
+
+	// showError 以红色显示最近一次错误，停留时间比成功提示更长
+	showError := func(msg string) {
+		flashTip("× "+msg, color.NRGBA{220, 50, 50, 255}, time.Second*4)
+	}
+
+	// expanding 防止 SetText 触发的 OnChanged 再次展开缩写
+	expanding := false
+	// filtering 表示输入框正在实时编辑筛选关键字
+	filtering := false
+	setFilter := func(f string) {
+		if f == trayFilter {
+			return
+		}
+		trayFilter = f
+		rebuildTray()
+	}
+	entry.OnChanged = func(s string) {
+		// 刚输入空白时，展开其前面的那个词
+		if r, size := utf8.DecodeLastRuneInString(s); !expanding && unicode.IsSpace(r) {
+			prefix, token := splitLastToken(s[:len(s)-size])
+			if expanded := expandAbbreviations(token, config.Abbreviations); expanded != token {
+				expanding = true
+				entry.SetText(prefix + expanded + string(r))
+				expanding = false
+				return
+			}
+		}
+		// 单行模式下粘贴的多行文本提交时按行拆分，每行单独截断，这里只显示行数
+		if !config.MultiLine && strings.Contains(s, "\n") {
+			leftTips.Text = fmt.Sprintf("%d 条", len(splitLines(s)))
+			leftTips.Refresh()
+			return
+		}
+		currentW := getWeight(s, config.WeightMode)
+		limit := config.inputLimit()
+		if currentW > limit {
+			entry.SetText(truncateByWeight(s, limit, config.WeightMode))
+			return
+		}
+		leftTips.Text = inputTips(s, limit, config.WeightMode)
+		leftTips.Refresh()
+
+		// 以筛选前缀开头时实时筛选托盘；删掉前缀则恢复显示全部
+		if rest, ok := strings.CutPrefix(s, filterPrefix); ok {
+			filtering = true
+			setFilter(strings.TrimSpace(rest))
+		} else if filtering {
+			filtering = false
+			setFilter("")
+		}
+	}
+
+	// 多行模式下回车用于换行，提供一个提交按钮
+	submitBtn := widget.NewButton("提交", func() {
+		entry.OnSubmitted(entry.Text)
+	})
+
+	bottomBar := container.New(layout.NewHBoxLayout(),
+		leftTips,
+		layout.NewSpacer(),
+		rightTips,
+		submitBtn,
+	)
+	content := container.NewPadded(
+		container.NewBorder(nil, bottomBar, nil, nil, entry),
+	)
+
+	inputWin.SetContent(content)
+	// 首次显示时居中，之后的位置交给窗口管理器
+	inputWin.CenterOnScreen()
+
+	// geom 为两种输入模式下的窗口尺寸，隐藏窗口时记录用户调整后的尺寸
+	geom := loadWindowGeometry()
+	// dialogShown 为 true 时窗口被对话框临时调大，此时的尺寸不应记录
+	dialogShown := false
+	rememberSize := func() {
+		if dialogShown {
+			return
+		}
+		size := inputWin.Canvas().Size()
+		cur := windowSize{size.Width, size.Height}
+		slot := &geom.SingleLine
+		if config.MultiLine {
+			slot = &geom.MultiLine
+		}
+		if cur == *slot || cur.Width <= 0 || cur.Height <= 0 {
+			return
+		}
+		*slot = cur
+		if err := saveWindowGeometry(geom); err != nil {
+			log.Printf("Failed to save window geometry: %v", err)
+		}
+	}
+
+	// applyInputMode 按配置在单行和多行输入之间切换，多行模式使用更高的窗口
+	applyInputMode := func() {
+		entry.MultiLine = config.MultiLine
+		rightTips.Text = defaultTip()
+		rightTips.Refresh()
+		if config.MultiLine {
+			entry.Wrapping = fyne.TextWrapWord
+			submitBtn.Show()
+			inputWin.Resize(fyne.NewSize(geom.MultiLine.Width, geom.MultiLine.Height))
+		} else {
+			entry.Wrapping = fyne.TextWrapOff
+			submitBtn.Hide()
+			inputWin.Resize(fyne.NewSize(geom.SingleLine.Width, geom.SingleLine.Height))
+		}
+		entry.Refresh()
+	}
+	applyInputMode()
+
+	// showSaveError 在重试后仍无法保存待办时弹出对话框，确保用户知道修改没有写入磁盘
+	// 已有对话框时只在右下角提示，避免对话框叠加
+	showSaveError := func(err error) {
+		if dialogShown {
+			showError("保存失败，请查看日志")
+			return
+		}
+		showWindow()
+		rememberSize()
+		dialogShown = true
+		inputWin.Resize(fyne.NewSize(320, 180))
+		d := dialog.NewError(fmt.Errorf("待办未能保存，最近的修改只保留在内存中：%w", err), inputWin)
+		d.SetOnClosed(func() {
+			dialogShown = false
+			applyInputMode()
+		})
+		d.Show()
+	}
+
+	// editingID 为正在编辑的待办 ID，为空表示处于新增模式
+	editingID := ""
+	// subtaskOf 为正在为其新增子任务的待办 ID，为空表示不处于该模式
+	subtaskOf := ""
+	// draft 为开始编辑前输入框中尚未提交的内容，编辑结束（提交或放弃）后恢复
+	draft := ""
+	startEdit := func(t Todo) {
+		if editingID == "" && subtaskOf == "" {
+			draft = entry.Text
+		}
+		editingID = t.ID
+		subtaskOf = ""
+		inputWin.SetTitle("编辑待办")
+		entry.SetText(t.Text)
+		entry.CursorColumn = utf8.RuneCountInString(t.Text)
+		showWindow()
+	}
+	// startSubtask 打开输入窗口为 t 新增一条子任务
+	startSubtask := func(t Todo) {
+		if editingID == "" && subtaskOf == "" {
+			draft = entry.Text
+		}
+		editingID = ""
+		subtaskOf = t.ID
+		inputWin.SetTitle("新增子任务：" + truncateByWeightWithEllipsis(singleLine(t.Text), 20, config.WeightMode))
+		entry.SetText("")
+		showWindow()
+	}
+	stopEdit := func() {
+		editingID = ""
+		subtaskOf = ""
+		inputWin.SetTitle("新增待办")
+		entry.SetText(draft)
+		entry.CursorColumn = utf8.RuneCountInString(draft)
+		draft = ""
+	}
+
+	// moveTodo 将待办与托盘中显示在其上方（delta 为 -1）或下方（1）的相邻项交换，没有可交换的项时不做任何事
+	moveTodo := func(id string, delta int) {
+		todos := store.All()
+		now := time.Now()
+		order := filterOrder(todos, displayOrder(todos, now, config.SortAlpha, config.Reverse), trayFilter, config.FuzzyFilter)
+		other := moveTarget(todos, order, id, delta, now)
+		if other == "" || !store.Swap(id, other) {
+			return
+		}
+		if err := store.Save(); err != nil {
+			showSaveError(err)
+		}
+		rebuildTray()
+	}
+
+	var ok bool
 	tray, ok = a.(desktop.App)
 	if !ok {
 		log.Fatal("不支持托盘")
 	}
+	// 左键单击托盘图标直接显示输入窗口，右键打开菜单
+	// Windows、macOS 和大多数 Linux 桌面支持；不支持单击事件的 Linux 托盘实现仍可通过菜单中的“新增待办”打开
+	// SetSystemTrayWindow 会把关闭行为设为直接隐藏，因此关闭拦截必须在它之后设置
+	tray.SetSystemTrayWindow(inputWin)
+	// 单击托盘图标显示窗口时不经过 showWindow，在窗口获得焦点时补记状态
+	a.Lifecycle().SetOnEnteredForeground(func() {
+		windowVisible = true
+		lastActivity = time.Now()
+	})
+
+	// persistDraft 将未提交的输入写入 draft.txt，正在编辑时保存的是开始编辑前的输入
+	persistDraft := func() {
+		text := entry.Text
+		if editingID != "" || subtaskOf != "" {
+			text = draft
+		}
+		if err := saveDraft(text); err != nil {
+			log.Printf("Error saving draft: %v", err)
+		}
+	}
+
+	// hideWindow 隐藏输入窗口：放弃正在进行的编辑，未提交的输入保留到下次显示
+	hideWindow := func() {
+		if editingID != "" || subtaskOf != "" {
+			stopEdit()
+		}
+		persistDraft()
+		rememberSize()
+		inputWin.Hide()
+		windowVisible = false
+		lastActivity = time.Now()
+	}
+	inputWin.SetCloseIntercept(hideWindow)
+	entry.onEscape = hideWindow
+
+	// setFocus 设置或清除（s 为空）当前专注并持久化
+	setFocus := func(s string) {
+		config.Focus = s
+		if err := saveConfig(config); err != nil {
+			log.Printf("Error saving focus: %v", err)
+			showError("保存失败，请查看日志")
+		}
+		rebuildTray()
+	}
+
+	// toggleDone 切换指定待办的完成状态，并保存、刷新托盘
+	// 取消完成时从完成记录中减去原来完成的那一天，反复切换不会虚增统计和连续天数
+	toggleDone := func(id string) error {
+		completed := false
+		var undoneAt *time.Time
+		if t, ok := store.Update(id, func(t *Todo) {
+			t.Done = !t.Done
+			if !t.Done {
+				undoneAt = t.DoneAt
+				t.DoneAt = nil
+				return
+			}
+			completed = true
+			now := time.Now()
+			t.DoneAt = &now
+			// 重复待办完成后不保留完成状态，而是顺延到下一个周期
+			if t.Repeat != "" {
+				due := nextDue(t.Due, t.Repeat, now)
+				t.Due = &due
+				t.Done = false
+			}
+		}); ok && completed {
+			runHook("on_done", t)
+			completions.record(time.Now())
+			if err := saveCompletions(completions); err != nil {
+				log.Printf("Error saving stats: %v", err)
+			}
+		} else if ok && undoneAt != nil {
+			completions.unrecord(undoneAt.Local())
+			if err := saveCompletions(completions); err != nil {
+				log.Printf("Error saving stats: %v", err)
+			}
+		}
+		err := store.Save()
+		rebuildTray()
+		return err
+	}
+	// archivedCount 是 done.json 中的待办数量，用于在菜单中显示
+	archivedCount := 0
+	// loadSideFiles 读取回收站、归档数量和完成记录，并清理过期的回收站条目和一年以前的完成记录
+	// 启动时和切换列表后调用；加密数据在启动时无法解密的，解锁后再调用一次
+	loadSideFiles := func() {
+		if loaded, err := loadTrash(); err != nil {
+			log.Printf("Error loading trash: %v", err)
+		} else {
+			trash = pruneTrash(loaded, time.Now())
+			if len(trash) != len(loaded) {
+				if err := saveTrash(trash); err != nil {
+					log.Printf("Error saving trash: %v", err)
+				}
+			}
+		}
+		if archived, err := loadArchive(); err != nil {
+			log.Printf("Error reading archive file: %v", err)
+		} else {
+			archivedCount = len(archived)
+		}
+		c, err := loadCompletions()
+		if err != nil {
+			log.Printf("Error loading stats: %v", err)
+		} else if c.prune(time.Now()) {
+			if err := saveCompletions(c); err != nil {
+				log.Printf("Error saving stats: %v", err)
+			}
+		}
+		completions = c
+	}
+	loadSideFiles()
+
+	// rearmWatcher 在切换列表后让文件监听改为监听新数据文件所在的目录，监听启动后赋值
+	rearmWatcher := func() {}
+
+	// switchList 切换到名为 name 的列表（为空表示默认列表），不存在时新建，并保存到配置
+	switchList := func(name string) {
+		if listFile(name) != files.Path() {
+			if err := store.SwitchFile(listFile(name)); err != nil {
+				log.Printf("Error switching to list %q: %v", name, err)
+				showError("切换列表失败")
+				return
+			}
+			// 撤销记录属于原来的列表，回收站、归档和完成记录也随列表切换
+			lastDeleted = nil
+			activeList = name
+			setListFiles(name)
+			loadSideFiles()
+			rearmWatcher()
+			// 新建的列表立即写出文件，使其出现在“切换列表”菜单中
+			if _, err := os.Stat(listFile(name)); os.IsNotExist(err) {
+				if err := store.Save(); err != nil {
+					showSaveError(err)
+				}
+			}
+		}
+		if config.List != name {
+			config.List = name
+			if err := saveConfig(config); err != nil {
+				log.Printf("Error saving config: %v", err)
+			}
+		}
+		rebuildTray()
+	}
+
+	// archiveDone 将已完成的待办移入 done.json；写入归档失败时把它们放回列表
+	archiveDone := func() {
+		archived, err := loadArchive()
+		if err != nil {
+			log.Printf("Error reading archive file: %v", err)
+			showError("归档失败")
+			return
+		}
+		// 在一次 Store 操作中取出并移除已完成的待办，期间新完成的待办不会被漏掉
+		done := store.RemoveDone()
+		if len(done) == 0 {
+			return
+		}
+		now := time.Now()
+		entries := slices.Clone(done)
+		for i := range entries {
+			if entries[i].DoneAt == nil {
+				entries[i].DoneAt = &now
+			}
+		}
+		if err := saveArchive(append(archived, entries...)); err != nil {
+			log.Printf("Error writing archive file: %v", err)
+			showError("归档失败")
+			// 放回列表末尾，避免丢失
+			store.Add(done...)
+			rebuildTray()
+			return
+		}
+		archivedCount = len(archived) + len(done)
+		if err := store.Save(); err != nil {
+			showSaveError(err)
+		}
+		log.Printf("Archived %d completed todos", len(done))
+		rebuildTray()
+	}
+
+	// confirm 在输入窗口中弹出确认对话框，确认后执行 action
+	// 输入窗口较小，显示对话框期间临时调高窗口
+	confirm := func(title, message string, action func()) {
+		showWindow()
+		rememberSize()
+		dialogShown = true
+		inputWin.Resize(fyne.NewSize(320, 180))
+		dialog.ShowConfirm(title, message, func(ok bool) {
+			dialogShown = false
+			applyInputMode()
+			if ok {
+				action()
+			}
+		}, inputWin)
+	}
+
+	// showDetail 在输入窗口中弹出对话框，原样显示待办的完整文本（含换行），过长时可滚动
+	showDetail := func(t Todo) {
+		showWindow()
+		rememberSize()
+		dialogShown = true
+		inputWin.Resize(fyne.NewSize(360, 280))
+		text := widget.NewLabel(t.Text)
+		text.Wrapping = fyne.TextWrapWord
+		text.Selectable = true
+		scroll := container.NewVScroll(text)
+		scroll.SetMinSize(fyne.NewSize(300, 160))
+		d := dialog.NewCustom("详情", "关闭", scroll, inputWin)
+		d.SetOnClosed(func() {
+			dialogShown = false
+			applyInputMode()
+		})
+		d.Show()
+	}
+
+	// showAbout 在输入窗口中显示版本信息
+	showAbout := func() {
+		showWindow()
+		rememberSize()
+		dialogShown = true
+		inputWin.Resize(fyne.NewSize(320, 180))
+		d := dialog.NewInformation("关于", "mytodo "+version, inputWin)
+		d.SetOnClosed(func() {
+			dialogShown = false
+			applyInputMode()
+		})
+		d.Show()
+	}
+
+	// promptPassphrase 询问加密口令，解密成功后载入待办；口令错误时重新询问，取消则保持锁定
+	var promptPassphrase func()
+	promptPassphrase = func() {
+		showWindow()
+		rememberSize()
+		dialogShown = true
+		inputWin.Resize(fyne.NewSize(320, 180))
+		pw := widget.NewPasswordEntry()
+		d := dialog.NewForm("解锁待办", "解锁", "取消", []*widget.FormItem{widget.NewFormItem("口令", pw)}, func(ok bool) {
+			dialogShown = false
+			applyInputMode()
+			if !ok {
+				showError("待办已锁定，修改不会保存")
+				rebuildTray()
+				return
+			}
+			setPassphrase(pw.Text)
+			if err := store.Load(); err != nil {
+				promptPassphrase()
+				showError("口令错误")
+				return
+			}
+			loadSideFiles()
+			if entry.Text == "" {
+				if text := loadDraft(); text != "" {
+					entry.SetText(text)
+					entry.CursorColumn = utf8.RuneCountInString(text)
+				}
+			}
+			rebuildTray()
+		}, inputWin)
+		d.Show()
+	}
+
+	// removeFromTrash 从回收站移除指定 ID 的条目并保存，返回被移除的条目
+	removeFromTrash := func(id string) (trashedTodo, bool) {
+		for i, t := range trash {
+			if t.ID != id {
+				continue
+			}
+			trash = append(trash[:i:i], trash[i+1:]...)
+			if err := saveTrash(trash); err != nil {
+				log.Printf("Error saving trash: %v", err)
+			}
+			return t, true
+		}
+		return trashedTodo{}, false
+	}
+
+	// deleteTodo 删除指定待办，记录下来以便撤销，并放入回收站
+	deleteTodo := func(id string) {
+		t, idx := store.Delete(id)
+		if idx < 0 {
+			return
+		}
+		lastDeleted = &deletedTodo{todo: t, index: idx}
+		trash = pruneTrash(append([]trashedTodo{{Todo: t, DeletedAt: time.Now(), List: activeList}}, trash...), time.Now())
+		if err := saveTrash(trash); err != nil {
+			log.Printf("Error saving trash: %v", err)
+		}
+		if err := store.Save(); err != nil {
+			showSaveError(err)
+		}
+		rebuildTray()
+	}
+	undoDelete := func() {
+		if lastDeleted == nil {
+			return
+		}
+		store.Insert(lastDeleted.index, lastDeleted.todo)
+		removeFromTrash(lastDeleted.todo.ID)
+		lastDeleted = nil
+		if err := store.Save(); err != nil {
+			showSaveError(err)
+		}
+		rebuildTray()
+	}
+	// restoreTodo 将回收站中的待办恢复到原来所在列表的末尾
+	// 回收站按列表分开之前删除的待办可能来自其他列表，此时直接写入那个列表的数据文件
+	restoreTodo := func(id string) {
+		t, ok := removeFromTrash(id)
+		if !ok {
+			return
+		}
+		if lastDeleted != nil && lastDeleted.todo.ID == id {
+			lastDeleted = nil
+		}
+		if t.List == activeList {
+			store.Add(t.Todo)
+			if err := store.Save(); err != nil {
+				showSaveError(err)
+			}
+			rebuildTray()
+			return
+		}
+		other := newFileStore(listFile(t.List), fileCodec{}, func() int { return config.Backups })
+		err := other.Load()
+		if err == nil {
+			other.Add(t.Todo)
+			err = other.Save()
+		}
+		if err != nil {
+			log.Printf("Error restoring todo to list %q: %v", t.List, err)
+			// 放回回收站，以免丢失
+			trash = append([]trashedTodo{t}, trash...)
+			if err := saveTrash(trash); err != nil {
+				log.Printf("Error saving trash: %v", err)
+			}
+			showSaveError(err)
+		} else {
+			name := t.List
+			if name == "" {
+				name = "默认"
+			}
+			flashTip("已恢复到列表："+name, color.NRGBA{50, 205, 50, 255}, time.Second*2)
+		}
+		rebuildTray()
+	}
+
+	// 输入框为空时按 1-9 切换托盘菜单顶层第 n 条待办（标签前的编号）的完成状态，超出时照常输入数字
+	entry.onDigit = func(n int) bool {
+		todos := store.All()
+		order := filterOrder(todos, displayOrder(todos, time.Now(), config.SortAlpha, config.Reverse), trayFilter, config.FuzzyFilter)
+		direct := groupForTray(todos, order, config.MaxTrayItems).direct
+		if n > len(direct) {
+			return false
+		}
+		t := todos[direct[n-1]]
+		if err := toggleDone(t.ID); err != nil {
+			showSaveError(err)
+			return true
+		}
+		mark := "√ 已完成："
+		if t.Done {
+			mark = "已恢复："
+		}
+		flashTip(mark+truncateByWeightWithEllipsis(singleLine(t.Text), 20, config.WeightMode), color.NRGBA{50, 205, 50, 255}, time.Second*2)
+		return true
+	}
+
+	toggleDoneAt = func(n int) error {
+		if dataLocked() {
+			return errLocked
+		}
+		todos := store.All()
+		if n < 1 || n > len(todos) {
+			return fmt.Errorf("index %d out of range (1-%d)", n, len(todos))
+		}
+		return toggleDone(todos[n-1].ID)
+	}
+
+	removeAt = func(n int) error {
+		if dataLocked() {
+			return errLocked
+		}
+		todos := store.All()
+		if n < 1 || n > len(todos) {
+			return fmt.Errorf("index %d out of range (1-%d)", n, len(todos))
+		}
+		deleteTodo(todos[n-1].ID)
+		return nil
+	}
+
+	// togglePin 切换待办的置顶状态
+	togglePin := func(id string) {
+		if _, ok := store.Update(id, func(t *Todo) { t.Pinned = !t.Pinned }); !ok {
+			return
+		}
+		if err := store.Save(); err != nil {
+			showSaveError(err)
+		}
+		rebuildTray()
+	}
+
+	// deleteSubtask 从 parentID 的子任务中删除 id，子任务不进入回收站
+	deleteSubtask := func(parentID, id string) {
+		if _, ok := store.Update(parentID, func(p *Todo) {
+			if i := indexByID(p.Subtasks, id); i >= 0 {
+				p.Subtasks = append(p.Subtasks[:i:i], p.Subtasks[i+1:]...)
+			}
+		}); !ok {
+			return
+		}
+		if err := store.Save(); err != nil {
+			showSaveError(err)
+		}
+		rebuildTray()
+	}
+
+	// todoItem 构建一条待办的菜单项，子菜单中是针对该待办的操作，之后列出其子任务
+	// parentID 不为空时 t 是该待办的子任务，删除只作用于父待办中的这一项，且不提供上移、下移
+	var todoItem func(t Todo, parentID string, now time.Time) *fyne.MenuItem
+	todoItem = func(t Todo, parentID string, now time.Time) *fyne.MenuItem {
+		mark := "☐ "
+		if t.Done {
+			mark = "☑ "
+		}
+		label := mark + priorityBadge(t.Priority) + truncateByWeightWithEllipsis(singleLine(t.Text), config.displayLimit(), config.WeightMode)
+		if t.Pinned {
+			label = "📌 " + label
+		}
+		if done, total := subtaskProgress(t); total > 0 {
+			label += fmt.Sprintf(" (%d/%d)", done, total)
+		}
+		if t.Repeat != "" {
+			label += " 🔁"
+		}
+		if isOverdue(t, now) {
+			label += " (逾期)"
+		} else if t.Due != nil && !t.Done {
+			label += " 📅" + t.Due.Format("01-02")
+		}
+		toggleLabel := "☑ 完成"
+		if t.Done {
+			toggleLabel = "☐ 取消完成"
+		}
+		item := fyne.NewMenuItem(label, nil)
+		// t 是本次调用的参数，各个闭包捕获的就是这条待办
+		remove := func() {
+			if parentID != "" {
+				deleteSubtask(parentID, t.ID)
+			} else {
+				deleteTodo(t.ID)
+			}
+		}
+		actions := []*fyne.MenuItem{
+			fyne.NewMenuItem(toggleLabel, func() {
+				// 按 ID 切换完成状态，文本重复时也不会误操作
+				if err := toggleDone(t.ID); err != nil {
+					showSaveError(err)
+				}
+			}),
+			fyne.NewMenuItem("✎ 编辑", func() { startEdit(t) }),
+			fyne.NewMenuItem("➕ 添加子任务", func() { startSubtask(t) }),
+			fyne.NewMenuItem("🔍 详情", func() { showDetail(t) }),
+			fyne.NewMenuItem("📋 复制", func() { a.Clipboard().SetContent(t.Text) }),
+		}
+		if parentID == "" {
+			pin := fyne.NewMenuItem("置顶", func() { togglePin(t.ID) })
+			pin.Checked = t.Pinned
+			actions = append(actions, pin)
+			// 按字母排序或模糊筛选时显示顺序与存储顺序无关，不提供移动
+			if !config.SortAlpha && (trayFilter == "" || !config.FuzzyFilter) {
+				actions = append(actions,
+					fyne.NewMenuItem("↑ 上移", func() { moveTodo(t.ID, -1) }),
+					fyne.NewMenuItem("↓ 下移", func() { moveTodo(t.ID, 1) }),
+				)
+			}
+		}
+		actions = append(actions, fyne.NewMenuItem("🗑 删除", func() {
+			if !config.ConfirmDelete {
+				remove()
+				return
+			}
+			confirm("确认删除", "删除“"+truncateByWeightWithEllipsis(singleLine(t.Text), config.displayLimit(), config.WeightMode)+"”？", remove)
+		}))
+		if len(t.Subtasks) > 0 {
+			actions = append(actions, fyne.NewMenuItemSeparator())
+			for _, st := range t.Subtasks {
+				actions = append(actions, todoItem(st, t.ID, now))
+			}
+		}
+		item.ChildMenu = fyne.NewMenu("", actions...)
+		return item
+	}
 
 	rebuildTray = func() {
 		fyne.Do(func() {
+			// 每次菜单操作都会重建托盘，借此记录活动时间
+			lastActivity = time.Now()
+			todos := store.All()
 			var items []*fyne.MenuItem
-			items = append(items, fyne.NewMenuItem("➕ 新增待办", func() {
-				inputWin.Show()
-				inputWin.RequestFocus()
+			if dataLocked() {
+				items = append(items, fyne.NewMenuItem("🔒 解锁待办…", promptPassphrase))
+			}
+			if label := config.focusHeader(); label != "" {
+				header := fyne.NewMenuItem(label, nil)
+				header.Disabled = true
+				items = append(items, header)
+			}
+			addLabel, headerSeparator, emptyLabel := trayHeader(config.CompactMenu, len(todos))
+			items = append(items, fyne.NewMenuItem(addLabel, showWindow))
+			if config.Focus == "" {
+				items = append(items, fyne.NewMenuItem("🎯 设置专注", func() {
+					entry.SetText(focusPrefix)
+					entry.CursorColumn = utf8.RuneCountInString(focusPrefix)
+					showWindow()
+				}))
+			} else {
+				items = append(items, fyne.NewMenuItem("🎯 清除专注", func() {
+					setFocus("")
+				}))
+			}
+			// 切换列表：默认列表、数据目录中已有的列表，以及新建列表
+			current := "默认"
+			if config.List != "" {
+				current = config.List
+			}
+			defaultList := fyne.NewMenuItem("默认", func() { switchList("") })
+			defaultList.Checked = config.List == ""
+			listItems := []*fyne.MenuItem{defaultList}
+			for _, name := range listNames() {
+				item := fyne.NewMenuItem(name, func() { switchList(name) })
+				item.Checked = name == config.List
+				listItems = append(listItems, item)
+			}
+			listItems = append(listItems, fyne.NewMenuItemSeparator(), fyne.NewMenuItem("➕ 新建列表…", func() {
+				entry.SetText(listPrefix)
+				entry.CursorColumn = utf8.RuneCountInString(listPrefix)
+				showWindow()
 			}))
-			items = append(items, fyne.NewMenuItemSeparator())
+			listMenu := fyne.NewMenuItem("📂 切换列表（当前："+current+"）", nil)
+			listMenu.ChildMenu = fyne.NewMenu("", listItems...)
+			items = append(items, listMenu)
+			if headerSeparator {
+				items = append(items, fyne.NewMenuItemSeparator())
+			}
+
+			if trayFilter != "" {
+				filterHeader := fyne.NewMenuItem("🔍 筛选: "+truncateByWeightWithEllipsis(trayFilter, config.displayLimit(), config.WeightMode), nil)
+				filterHeader.Disabled = true
+				items = append(items, filterHeader, fyne.NewMenuItem("清除筛选", func() {
+					trayFilter = ""
+					rebuildTray()
+				}))
+			}
 
 			if len(todos) == 0 {
-				items = append(items, fyne.NewMenuItem("（暂无待办）", nil))
+				if emptyLabel != "" {
+					items = append(items, fyne.NewMenuItem(emptyLabel, nil))
+				}
 			} else {
-				for i := range todos {
-					t := todos[i]
-					label := "☐ " + truncateByWeightWithEllipsis(t.Text, maxShowWeight)
-					items = append(items, fyne.NewMenuItem(label, func(itemText string) func() {
-						return func() {
-							for idx, item := range todos {
-								if item.Text == itemText {
-									todos = append(todos[:idx], todos[idx+1:]...)
-									break
-								}
-							}
-							saveTodos(todos)
-							rebuildTray()
+				now := time.Now()
+				// 筛选只影响显示，菜单动作仍按 ID 作用于原始待办
+				order := filterOrder(todos, displayOrder(todos, now, config.SortAlpha, config.Reverse), trayFilter, config.FuzzyFilter)
+				// 未打标签的待办直接列出，超出 MaxTrayItems 的放入“更多…”，其余按标签分组到子菜单
+				// 顶层前 9 条标上数字键编号，与 entry.onDigit 使用同一划分
+				groups := groupForTray(todos, order, config.MaxTrayItems)
+				for n, i := range groups.direct {
+					item := todoItem(todos[i], "", now)
+					if n < 9 {
+						item.Label = fmt.Sprintf("%d. %s", n+1, item.Label)
+					}
+					items = append(items, item)
+				}
+				if len(groups.overflow) > 0 {
+					var overflow []*fyne.MenuItem
+					for _, i := range groups.overflow {
+						overflow = append(overflow, todoItem(todos[i], "", now))
+					}
+					more := fyne.NewMenuItem(fmt.Sprintf("更多…（%d）", len(overflow)), nil)
+					more.ChildMenu = fyne.NewMenu("", overflow...)
+					items = append(items, more)
+				}
+				tags := make([]string, 0, len(groups.tagged))
+				for tag := range groups.tagged {
+					tags = append(tags, tag)
+				}
+				sort.Strings(tags)
+				for _, tag := range tags {
+					var tagged []*fyne.MenuItem
+					for _, i := range groups.tagged[tag] {
+						tagged = append(tagged, todoItem(todos[i], "", now))
+					}
+					group := fyne.NewMenuItem(fmt.Sprintf("#%s (%d)", tag, len(tagged)), nil)
+					group.ChildMenu = fyne.NewMenu("", tagged...)
+					items = append(items, group)
+				}
+				if len(order) == 0 {
+					items = append(items, fyne.NewMenuItem("（无匹配待办）", nil))
+				}
+			}
+
+			// 统计信息，每次重建菜单时重新计算
+			st := stats(todos, time.Now())
+			statsLabel := fmt.Sprintf("今日完成: %d / 总计: %d", st.DoneToday, st.Total)
+			if config.MaxTodos > 0 {
+				statsLabel += fmt.Sprintf("（上限 %d）", config.MaxTodos)
+			}
+			if n := completions.streak(time.Now()); n > 0 {
+				statsLabel += fmt.Sprintf(" / 连续 %d 天", n)
+			}
+			if st.AvgAge > 0 {
+				statsLabel += fmt.Sprintf(" / 平均 %.1f 天", st.AvgAge.Hours()/24)
+			}
+			statsItem := fyne.NewMenuItem(statsLabel, nil)
+			statsItem.Disabled = true
+			items = append(items, fyne.NewMenuItemSeparator(), statsItem)
+
+			if lastDeleted != nil {
+				items = append(items, fyne.NewMenuItem("↶ 撤销删除", undoDelete))
+			}
+			items = append(items, fyne.NewMenuItem(fmt.Sprintf("📦 归档已完成（已归档 %d）", archivedCount), archiveDone))
+			if len(trash) > 0 {
+				var trashItems []*fyne.MenuItem
+				for _, t := range trash {
+					id := t.ID
+					label := t.DeletedAt.Format("01-02 15:04") + " " + truncateByWeightWithEllipsis(singleLine(t.Text), config.displayLimit(), config.WeightMode)
+					if t.List != activeList {
+						// 回收站按列表分开之前删除的其他列表的待办
+						name := t.List
+						if name == "" {
+							name = "默认"
 						}
-					}(t.Text))) // 使用闭包捕获正确的 todo 项
+						label += "（" + name + "）"
+					}
+					item := fyne.NewMenuItem(label, nil)
+					item.ChildMenu = fyne.NewMenu("", fyne.NewMenuItem("↶ 恢复", func() { restoreTodo(id) }))
+					trashItems = append(trashItems, item)
+				}
+				trashMenu := fyne.NewMenuItem(fmt.Sprintf("🗑 回收站（%d）", len(trash)), nil)
+				trashMenu.ChildMenu = fyne.NewMenu("", trashItems...)
+				items = append(items, trashMenu)
+			}
+
+			items = append(items, fyne.NewMenuItemSeparator(), fyne.NewMenuItem("排序：最早优先", func() {
+				// 没有创建时间的旧待办视为最早
+				store.Sort(func(a, b Todo) bool {
+					return a.Created.Before(b.Created)
+				})
+				if err := store.Save(); err != nil {
+					showSaveError(err)
+				}
+				rebuildTray()
+			}))
+			alphaItem := fyne.NewMenuItem("按字母排序", func() {
+				config.SortAlpha = !config.SortAlpha
+				if err := saveConfig(config); err != nil {
+					log.Printf("Error saving config: %v", err)
+				}
+				rebuildTray()
+			})
+			alphaItem.Checked = config.SortAlpha
+			reverseItem := fyne.NewMenuItem("倒序显示", func() {
+				config.Reverse = !config.Reverse
+				if err := saveConfig(config); err != nil {
+					log.Printf("Error saving config: %v", err)
+				}
+				rebuildTray()
+			})
+			reverseItem.Checked = config.Reverse
+			items = append(items, alphaItem, reverseItem)
+
+			multiLineItem := fyne.NewMenuItem("多行输入", func() {
+				config.MultiLine = !config.MultiLine
+				if err := saveConfig(config); err != nil {
+					log.Printf("Error saving config: %v", err)
+				}
+				applyInputMode()
+				rebuildTray()
+			})
+			multiLineItem.Checked = config.MultiLine
+			confirmItem := fyne.NewMenuItem("确认删除", func() {
+				config.ConfirmDelete = !config.ConfirmDelete
+				if err := saveConfig(config); err != nil {
+					log.Printf("Error saving config: %v", err)
 				}
+				rebuildTray()
+			})
+			confirmItem.Checked = config.ConfirmDelete
+			items = append(items, multiLineItem, confirmItem)
+			// XDG 自启动目录只在 Linux 桌面上有效
+			if runtime.GOOS == "linux" {
+				enabled := autostartEnabled()
+				autostartItem := fyne.NewMenuItem("开机启动", func() {
+					if err := setAutostart(!enabled); err != nil {
+						log.Printf("Error updating autostart entry: %v", err)
+						showError("设置开机启动失败")
+					}
+					rebuildTray()
+				})
+				autostartItem.Checked = enabled
+				items = append(items, autostartItem)
 			}
 
-			items = append(items, fyne.NewMenuItemSeparator(), fyne.NewMenuItem("退出", func() {
+			items = append(items, fyne.NewMenuItemSeparator(), fyne.NewMenuItem("导出 Markdown", func() {
+				path := filepath.Join(dataDir, "todos.md")
+				all := store.All()
+				if err := exportMarkdown(all, path); err != nil {
+					log.Printf("Error exporting markdown: %v", err)
+					showError("导出失败")
+					return
+				}
+				log.Printf("Exported %d todos to %s", len(all), path)
+			}), fyne.NewMenuItem("导出日历", func() {
+				path := filepath.Join(dataDir, "todos.ics")
+				n, err := exportICS(store.All(), path)
+				if err != nil {
+					log.Printf("Error exporting calendar: %v", err)
+					showError("导出失败")
+					return
+				}
+				log.Printf("Exported %d dated todos to %s", n, path)
+			}), fyne.NewMenuItem("复制全部", func() {
+				all := store.All()
+				a.Clipboard().SetContent(plainText(all))
+				log.Printf("Copied %d todos to clipboard", len(all))
+			}), fyne.NewMenuItem("导入文本", func() {
+				// 从数据目录下的 import.txt 导入，每行一条
+				path := filepath.Join(dataDir, "import.txt")
+				imported, err := importFromFile(path)
+				if err != nil {
+					log.Printf("Error importing todos: %v", err)
+					if os.IsNotExist(err) {
+						showError("导入失败: 找不到 import.txt")
+					} else {
+						showError("导入失败")
+					}
+					return
+				}
+				if config.Dedup {
+					imported = dedupTodos(store.All(), imported)
+				}
+				store.Add(imported...)
+				if err := store.Save(); err != nil {
+					showSaveError(err)
+				}
+				log.Printf("Imported %d todos from %s", len(imported), path)
+				rebuildTray()
+			}))
+
+			items = append(items, fyne.NewMenuItemSeparator(), fyne.NewMenuItem("清空全部", func() {
+				confirm("清空全部", fmt.Sprintf("删除全部 %d 条待办？\n清空前的列表会保存到 todo.json.bak", len(store.All())), func() {
+					// 备份失败时不清空，避免数据无法找回
+					if err := backupTodos(files.Path(), store.All()); err != nil {
+						log.Printf("Error backing up todos before clearing: %v", err)
+						showError("备份失败，未清空")
+						return
+					}
+					old := store.Clear()
+					if err := store.Save(); err != nil {
+						showSaveError(err)
+					}
+					log.Printf("Cleared %d todos", len(old))
+					rebuildTray()
+				})
+			}), fyne.NewMenuItem("关于", showAbout), fyne.NewMenuItem("退出", func() {
 				// 清理 socket 文件
-				_ = os.Remove(socketPath)
+				ipc.Cleanup()
 				a.Quit()
 			}))
 			tray.SetSystemTrayMenu(fyne.NewMenu("Todo", items...))
+
+			pending := 0
+			for _, t := range todos {
+				if !t.Done {
+					pending++
+				}
+			}
+			icon := trayIcon
+			if pending > 0 && trayBadgeBase != nil {
+				if badge := renderBadgeIcon(pending, trayBadgeBase); badge != nil {
+					icon = badge
+				}
+			}
+			if icon != nil {
+				tray.SetSystemTrayIcon(icon)
+			}
 		})
 	}
 
+	// 全局快捷键：按下时呼出输入窗口，修改配置后重新注册
+	var stopHotkey func()
+	registerHotkey := func() {
+		if stopHotkey != nil {
+			stopHotkey()
+			stopHotkey = nil
+		}
+		if config.Hotkey == "" {
+			return
+		}
+		stop, err := startHotkey(config.Hotkey, func() { fyne.Do(showWindow) })
+		if err != nil {
+			log.Printf("Warning: global hotkey %q not registered: %v", config.Hotkey, err)
+			return
+		}
+		stopHotkey = stop
+	}
+	// addFromCommandLine 在 addTodo 赋值之后定义，启动完成时调用
+	var addFromCommandLine func(text string)
+	a.Lifecycle().SetOnStarted(func() {
+		registerHotkey()
+		if dataLocked() {
+			promptPassphrase()
+		}
+		// 由命令行启动的 add 在没有其他实例时由本实例直接处理
+		if text, ok := strings.CutPrefix(message, "add "); ok {
+			addFromCommandLine(text)
+		}
+	})
+
+	// isDark 按配置或系统设置判断当前是否为深色主题
+	isDark := func() bool {
+		switch config.Theme {
+		case "dark":
+			return true
+		case "light":
+			return false
+		}
+		return a.Settings().ThemeVariant() == theme.VariantDark
+	}
+	// updateIcon 按当前主题重新选择托盘图标，主题未变化且已有图标时不做任何事
+	updateIcon := func(force bool) {
+		dark := isDark()
+		if !force && dark == iconDark {
+			return
+		}
+		iconDark = dark
+		if trayIcon, trayBadgeBase = loadTrayIcon(dark); trayIcon == nil {
+			log.Println("Could not find or create tray icon. The app will run without it.")
+		} else {
+			tray.SetSystemTrayIcon(trayIcon)
+		}
+		rebuildTray()
+	}
+	// applyTheme 按配置固定对话框等界面的明暗，"auto" 时使用跟随系统的默认主题
+	applyTheme := func() {
+		switch config.Theme {
+		case "light":
+			a.Settings().SetTheme(variantTheme{theme.DefaultTheme(), theme.VariantLight})
+		case "dark":
+			a.Settings().SetTheme(variantTheme{theme.DefaultTheme(), theme.VariantDark})
+		default:
+			a.Settings().SetTheme(theme.DefaultTheme())
+		}
+	}
+	applyTheme()
+	updateIcon(true)
+	// 系统主题在运行中切换时重绘图标
+	a.Settings().AddListener(func(fyne.Settings) {
+		fyne.Do(func() { updateIcon(false) })
+	})
+
+	applyConfig = func() {
+		// 通过 config:set 修改了 list 时切换到对应的列表
+		switchList(config.List)
+		registerHotkey()
+		applyInputMode()
+		entry.OnChanged(entry.Text)
+		applyTheme()
+		updateIcon(false)
+		rebuildTray()
+	}
+
+	// addTodos 对每段文本解析优先级标记、日期和重复关键词、展开缩写、按权重截断后追加待办，
+	// 并从文本中解析标签；全部追加后只保存、刷新托盘一次
+	// 开启去重时跳过已存在的文本，去掉空白后为空的文本也会跳过，返回实际追加的数量和因重复跳过的数量
+	// 待办数量达到 max_todos 且不是只提示时停止追加，保存已追加的部分后返回 errTodoLimit；
+	// 全部文本都为空时返回 errEmptyTodo
+	addTodos := func(texts []string) (int, int, error) {
+		added, dup, empty := 0, 0, 0
+		var limitErr error
+		for _, text := range texts {
+			if text = cleanTodoText(text, config.CollapseSpaces); text == "" {
+				empty++
+				continue
+			}
+			if config.MaxTodos > 0 && !config.MaxTodosWarnOnly && len(store.All()) >= config.MaxTodos {
+				limitErr = errTodoLimit
+				break
+			}
+			priority, text := parsePriority(text)
+			var due *time.Time
+			if d, rest, ok := parseDue(text, time.Now()); ok {
+				due, text = &d, rest
+			}
+			repeat, text := parseRepeat(text)
+			prefix, token := splitLastToken(text)
+			text = truncateByWeight(prefix+expandAbbreviations(token, config.Abbreviations), config.inputLimit(), config.WeightMode)
+			// 只有优先级标记或日期关键词时，去掉它们后不剩内容
+			if strings.TrimSpace(text) == "" {
+				empty++
+				continue
+			}
+			if config.Dedup && containsText(store.All(), text) {
+				dup++
+				continue
+			}
+			t := Todo{ID: newID(), Text: text, Priority: priority, Due: due, Repeat: repeat, Created: time.Now(), Tags: extractTags(text)}
+			store.Add(t)
+			runHook("on_add", t)
+			added++
+		}
+		if added == 0 && limitErr == nil && empty == len(texts) {
+			return 0, 0, errEmptyTodo
+		}
+		if added == 0 {
+			return 0, dup, limitErr
+		}
+		err := store.Save()
+		rebuildTray()
+		if err != nil {
+			return added, dup, err
+		}
+		return added, dup, limitErr
+	}
+
+	// limitReached 报告待办数量是否已达到 max_todos
+	limitReached := func() bool {
+		return config.MaxTodos > 0 && len(store.All()) >= config.MaxTodos
+	}
+
 	entry.OnSubmitted = func(text string) {
+		// 编辑或添加子任务时提交空白内容视为取消，恢复标题和之前的草稿
+		editing := editingID != "" || subtaskOf != ""
 		if text == "" {
+			if editing {
+				stopEdit()
+			}
+			return
+		}
+		// 全部为空白（包括单独的换行）时不保存，只给出提示
+		if text = cleanTodoText(text, config.CollapseSpaces); text == "" {
+			if editing {
+				stopEdit()
+			} else {
+				entry.SetText("")
+			}
+			flashTip("内容为空", color.NRGBA{230, 150, 30, 255}, time.Second*2)
+			return
+		}
+		if strings.HasPrefix(text, focusPrefix) {
+			setFocus(strings.TrimSpace(strings.TrimPrefix(text, focusPrefix)))
+			entry.SetText("")
+			return
+		}
+		if rest, ok := strings.CutPrefix(text, listPrefix); ok {
+			name := strings.TrimSpace(rest)
+			if name != "" && validateListName(name) != nil {
+				showError("列表名称不能包含空白或 / \\ : * ? \" < > |")
+				return
+			}
+			entry.SetText("")
+			switchList(name)
+			return
+		}
+		if strings.HasPrefix(text, filterPrefix) {
+			// 提交后保留筛选，清空输入框时不再重置
+			filtering = false
+			entry.SetText("")
+			return
+		}
+		if subtaskOf != "" {
+			parentID := subtaskOf
+			stopEdit()
+			prefix, token := splitLastToken(text)
+			text = truncateByWeight(prefix+expandAbbreviations(token, config.Abbreviations), config.inputLimit(), config.WeightMode)
+			sub := Todo{ID: newID(), Text: text, Created: time.Now(), Tags: extractTags(text)}
+			if _, ok := store.Update(parentID, func(t *Todo) {
+				t.Subtasks = append(t.Subtasks, sub)
+			}); !ok {
+				showError("待办已不存在")
+				return
+			}
+			if err := store.Save(); err != nil {
+				showSaveError(err)
+			} else {
+				flashTip("√ 子任务已添加", color.NRGBA{50, 205, 50, 255}, time.Second*2)
+			}
+			rebuildTray()
+			return
+		}
+		if editingID != "" {
+			id := editingID
+			stopEdit()
+			prefix, token := splitLastToken(text)
+			text = truncateByWeight(prefix+expandAbbreviations(token, config.Abbreviations), config.inputLimit(), config.WeightMode)
+			if _, ok := store.Update(id, func(t *Todo) {
+				t.Text = text
+				t.Tags = extractTags(text)
+			}); !ok {
+				showError("待办已不存在")
+				return
+			}
+			if err := store.Save(); err != nil {
+				showSaveError(err)
+			} else {
+				flashTip("√ 待办已修改", color.NRGBA{50, 205, 50, 255}, time.Second*2)
+			}
+			rebuildTray()
 			return
 		}
-		todos = append(todos, Todo{Text: text})
-		saveTodos(todos)
 		entry.SetText("")
-		showSuccess()
-		rebuildTray()
+		// 单行模式下粘贴的多行文本按行拆分为多条待办
+		if !config.MultiLine && strings.Contains(text, "\n") {
+			lines := splitLines(text)
+			added, dup, err := addTodos(lines)
+			switch {
+			case errors.Is(err, errEmptyTodo):
+				flashTip("内容为空", color.NRGBA{230, 150, 30, 255}, time.Second*2)
+			case errors.Is(err, errTodoLimit):
+				if added == 0 {
+					entry.SetText(text)
+				}
+				flashTip(fmt.Sprintf("已达上限，已提交 %d 条", added), color.NRGBA{230, 150, 30, 255}, time.Second*3)
+			case err != nil:
+				showSaveError(err)
+			case limitReached():
+				flashTip(fmt.Sprintf("√ 已提交 %d 条（已达上限）", added), color.NRGBA{230, 150, 30, 255}, time.Second*3)
+			case added < len(lines):
+				// 其余的行去掉优先级标记和日期后为空
+				tip := fmt.Sprintf("√ 已提交 %d 条", added)
+				if dup > 0 {
+					tip += fmt.Sprintf("，%d 条已存在", dup)
+				}
+				if empty := len(lines) - added - dup; empty > 0 {
+					tip += fmt.Sprintf("，%d 条为空", empty)
+				}
+				flashTip(tip, color.NRGBA{230, 150, 30, 255}, time.Second*2)
+			default:
+				flashTip(fmt.Sprintf("√ 已提交 %d 条待办", added), color.NRGBA{50, 205, 50, 255}, time.Second*2)
+			}
+			if err == nil {
+				persistDraft()
+			}
+			return
+		}
+		if err := addTodo(text); errors.Is(err, errDuplicate) {
+			flashTip("已存在", color.NRGBA{230, 150, 30, 255}, time.Second*2)
+		} else if errors.Is(err, errEmptyTodo) {
+			flashTip("内容为空", color.NRGBA{230, 150, 30, 255}, time.Second*2)
+		} else if errors.Is(err, errTodoLimit) {
+			// 被拒绝的内容放回输入框，归档或删除后可以再次提交
+			entry.SetText(text)
+			entry.CursorColumn = utf8.RuneCountInString(text)
+			flashTip("已达上限", color.NRGBA{230, 150, 30, 255}, time.Second*3)
+		} else if err != nil {
+			showSaveError(err)
+		} else if limitReached() {
+			persistDraft()
+			flashTip("√ 已提交（已达上限）", color.NRGBA{230, 150, 30, 255}, time.Second*3)
+		} else {
+			persistDraft()
+			showSuccess()
+		}
 	}
 
-	iconPath := ensureIcon()
-	if iconPath == "" {
-		log.Println("Could not find or create tray icon. The app will run without it.")
-	} else {
-		res, _ := fyne.LoadResourceFromPath(iconPath)
-		tray.SetSystemTrayIcon(res)
+	// 恢复上次退出时未提交的输入
+	if text := loadDraft(); text != "" {
+		entry.SetText(text)
+		entry.CursorColumn = utf8.RuneCountInString(text)
+	}
+
+	// addTodo 追加一条待办，开启去重且文本已存在时不追加，返回 errDuplicate
+	addTodo = func(text string) error {
+		added, dup, err := addTodos([]string{text})
+		if err == nil && added == 0 && dup > 0 {
+			return errDuplicate
+		}
+		return err
+	}
+
+	// addFromCommandLine 在界面启动后添加命令行传入的待办，与输入框提交一样提示失败原因
+	// 因上限或数据被锁定未能添加时把内容放进空的输入框，避免丢失
+	addFromCommandLine = func(text string) {
+		err := errLocked
+		if !dataLocked() {
+			err = addTodo(text)
+		}
+		if err == nil {
+			return
+		}
+		log.Printf("Error adding todo from command line: %v", err)
+		if (errors.Is(err, errTodoLimit) || errors.Is(err, errLocked)) && entry.Text == "" {
+			entry.SetText(cleanTodoText(text, config.CollapseSpaces))
+			entry.CursorColumn = utf8.RuneCountInString(entry.Text)
+		}
+		showWindow()
+		switch {
+		case errors.Is(err, errDuplicate):
+			flashTip("已存在", color.NRGBA{230, 150, 30, 255}, time.Second*2)
+		case errors.Is(err, errEmptyTodo):
+			flashTip("内容为空", color.NRGBA{230, 150, 30, 255}, time.Second*2)
+		case errors.Is(err, errTodoLimit):
+			flashTip("已达上限", color.NRGBA{230, 150, 30, 255}, time.Second*3)
+		case errors.Is(err, errLocked):
+			showError("数据已加密，解锁后请重新提交")
+		default:
+			showSaveError(err)
+		}
+	}
+
+	// stopped 在应用停止时关闭，后台 goroutine 据此结束
+	stopped := make(chan struct{})
+	a.Lifecycle().SetOnStopped(func() {
+		persistDraft()
+		close(stopped)
+	})
+
+	// 空闲自动退出：每分钟在主 goroutine 中检查一次，提醒窗口内有待办到期时推迟退出
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopped:
+				return
+			case <-ticker.C:
+			}
+			fyne.Do(func() {
+				// 已经开始退出时不再重复保存和退出
+				select {
+				case <-stopped:
+					return
+				default:
+				}
+				now := time.Now()
+				if windowVisible || !idleExpired(lastActivity, now, config.AutoQuitIdleMinutes) || dueWithin(store.All(), now, autoQuitDueWindow) {
+					return
+				}
+				// 保存失败时留在运行状态，避免退出丢失修改，下一分钟再试
+				if err := store.Save(); err != nil {
+					log.Printf("Error saving todos before idle quit, staying open: %v", err)
+					return
+				}
+				log.Printf("Idle for %d minutes, quitting.", config.AutoQuitIdleMinutes)
+				ipc.Cleanup()
+				a.Quit()
+			})
+		}
+	}()
+
+	// 收到 SIGINT/SIGTERM 时保存待办、清理 socket 后退出，避免留下过期的 socket 文件
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		s := <-sig
+		log.Printf("Received %v, saving and quitting.", s)
+		if err := store.Save(); err != nil {
+			log.Printf("Error saving todos on shutdown: %v", err)
+		}
+		ipc.Cleanup()
+		fyne.Do(a.Quit)
+	}()
+
+	if *serve != "" {
+		go serveHTTP(*serve, stopped)
 	}
-	rebuildTray()
+
+	// 到期提醒：每分钟检查一次，每个截止时间只提醒一次
+	go func() {
+		notified := make(map[string]bool)
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopped:
+				return
+			case <-ticker.C:
+			}
+			for _, t := range collectDue(store.All(), time.Now(), notified) {
+				n := fyne.NewNotification("待办到期", singleLine(t.Text))
+				fyne.Do(func() { a.SendNotification(n) })
+			}
+		}
+	}()
+
+	// 每日汇总：到达 summary_time 时发送一次通知，之后计算下一天的时刻
+	// 最多每分钟重新读取一次配置，运行时修改 summary_time 也能生效
+	go func() {
+		lastClock := ""
+		var next time.Time
+		for {
+			var clock string
+			fyne.DoAndWait(func() { clock = config.SummaryTime })
+			now := time.Now()
+			if clock != lastClock {
+				lastClock = clock
+				next = time.Time{}
+				if clock != "" {
+					next, _ = nextClockTime(now, clock)
+				}
+			}
+			if !next.IsZero() && !now.Before(next) {
+				n := fyne.NewNotification("今日待办", dailySummary(store.All(), now))
+				fyne.Do(func() { a.SendNotification(n) })
+				next, _ = nextClockTime(now, clock)
+			}
+			wait := time.Minute
+			if !next.IsZero() && next.Sub(now) < wait {
+				wait = next.Sub(now)
+			}
+			select {
+			case <-stopped:
+				return
+			case <-time.After(wait):
+			}
+		}
+	}()
+
+	// 外部修改 todo.json（手动编辑或同步）后自动重新加载
+	rearmWatcher = watchDataFile(stopped, files.Path, func() {
+		changed, err := files.Reload()
+		if err != nil {
+			log.Printf("Ignoring unreadable todo file change: %v", err)
+			return
+		}
+		if changed {
+			log.Printf("Todo file changed on disk, reloaded %d todos.", len(store.All()))
+			fyne.Do(rebuildTray)
+		}
+	})
 
 	// 确保在应用退出时清理 socket 文件
 	defer func() {
-		_ = os.Remove(socketPath)
+		ipc.Cleanup()
 	}()
 
 	a.Run()
@@ -0,0 +1,695 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestAllowedHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"127.0.0.1:8080", true},
+		{"localhost:8080", true},
+		{"[::1]:8080", true},
+		{"127.0.0.1:9090", false},
+		{"localhost", false},
+		{"evil.example:8080", false},
+		{"127.0.0.1.evil.example:8080", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := allowedHost(tt.host, "8080"); got != tt.want {
+			t.Errorf("allowedHost(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+// withPassphrase 在测试期间开启加密并使用口令 p，结束时恢复未加密的状态
+func withPassphrase(t *testing.T, p string) {
+	t.Helper()
+	setPassphrase(p)
+	config.Encrypt = true
+	t.Cleanup(func() {
+		setPassphrase("")
+		keyMu.Lock()
+		dataEncrypted = false
+		keyMu.Unlock()
+		config = Config{}
+	})
+}
+
+func TestEncryptedTodosRoundTrip(t *testing.T) {
+	withPassphrase(t, "正确的口令")
+	data, err := encodeTodos([]Todo{{ID: "a", Text: "买菜 #家庭"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("买菜")) {
+		t.Fatalf("encoded data contains plaintext: %s", data)
+	}
+	todos, migrated, err := migrateData(data)
+	if err != nil || migrated || len(todos) != 1 || todos[0].Text != "买菜 #家庭" {
+		t.Fatalf("migrateData = %v, %v, %v", todos, migrated, err)
+	}
+
+	// 配置被重置后仍按加密保存
+	config.Encrypt = false
+	again, err := encodeTodos(todos)
+	if err != nil || bytes.Contains(again, []byte("买菜")) {
+		t.Fatalf("re-encoding after encrypt was turned off = %s, %v", again, err)
+	}
+
+	setPassphrase("错误的口令")
+	if _, _, err := migrateData(data); !errors.Is(err, errWrongPassphrase) {
+		t.Fatalf("wrong passphrase: err = %v, want errWrongPassphrase", err)
+	}
+	if !dataLocked() {
+		t.Fatal("dataLocked() = false after a wrong passphrase")
+	}
+	if _, err := encodeTodos(todos); !errors.Is(err, errLocked) {
+		t.Fatalf("encode while locked: err = %v, want errLocked", err)
+	}
+}
+
+func TestGroupForTray(t *testing.T) {
+	todos := []Todo{
+		{Text: "甲"},
+		{Text: "乙 #工作", Tags: []string{"工作"}},
+		{Text: "丙"},
+		{Text: "丁 #工作 #家庭", Tags: []string{"工作", "家庭"}},
+		{Text: "戊"},
+	}
+	tests := []struct {
+		name      string
+		order     []int
+		maxDirect int
+		direct    []int
+		overflow  []int
+		tagged    map[string][]int
+	}{
+		{"unlimited", []int{0, 1, 2, 3, 4}, 0, []int{0, 2, 4}, nil, map[string][]int{"工作": {1, 3}, "家庭": {3}}},
+		{"tagged todos do not count against the limit", []int{0, 1, 2, 3, 4}, 2, []int{0, 2}, []int{4}, map[string][]int{"工作": {1, 3}, "家庭": {3}}},
+		{"display order is kept", []int{4, 3, 2, 1, 0}, 0, []int{4, 2, 0}, nil, map[string][]int{"工作": {3, 1}, "家庭": {3}}},
+		{"filtered", []int{2}, 0, []int{2}, nil, map[string][]int{}},
+	}
+	for _, tt := range tests {
+		g := groupForTray(todos, tt.order, tt.maxDirect)
+		if !reflect.DeepEqual(g.direct, tt.direct) || !reflect.DeepEqual(g.overflow, tt.overflow) || !reflect.DeepEqual(g.tagged, tt.tagged) {
+			t.Errorf("%s: groupForTray = %v %v %v, want %v %v %v", tt.name, g.direct, g.overflow, g.tagged, tt.direct, tt.overflow, tt.tagged)
+		}
+	}
+}
+
+func TestMoveTarget(t *testing.T) {
+	now := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	todos := []Todo{
+		{ID: "a", Text: "甲"},
+		{ID: "b", Text: "乙", Tags: []string{"工作"}},
+		{ID: "c", Text: "丙"},
+		{ID: "d", Text: "丁", Priority: 1},
+		{ID: "e", Text: "戊"},
+		{ID: "f", Text: "己", Tags: []string{"工作"}},
+	}
+	tests := []struct {
+		name    string
+		reverse bool
+		id      string
+		delta   int
+		want    string
+	}{
+		{"down skips other sections", false, "a", 1, "c"},
+		{"up", false, "e", -1, "c"},
+		{"top of the list", false, "a", -1, ""},
+		{"across a priority boundary", false, "d", 1, ""},
+		{"into a higher priority", false, "a", -1, ""},
+		{"within a tag", false, "b", 1, "f"},
+		{"reversed down", true, "e", 1, "c"},
+		{"reversed up", true, "a", -1, "c"},
+		{"reversed bottom", true, "a", 1, ""},
+		{"unknown id", false, "x", 1, ""},
+	}
+	for _, tt := range tests {
+		order := displayOrder(todos, now, false, tt.reverse)
+		if got := moveTarget(todos, order, tt.id, tt.delta, now); got != tt.want {
+			t.Errorf("%s: moveTarget(%s, %d) = %q, want %q", tt.name, tt.id, tt.delta, got, tt.want)
+		}
+	}
+}
+
+func TestIdleExpired(t *testing.T) {
+	last := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		now     time.Time
+		minutes int
+		want    bool
+	}{
+		{last.Add(29 * time.Minute), 30, false},
+		{last.Add(30 * time.Minute), 30, true},
+		{last.Add(24 * time.Hour), 0, false},
+		{last.Add(-time.Minute), 1, false},
+	}
+	for _, tt := range tests {
+		if got := idleExpired(last, tt.now, tt.minutes); got != tt.want {
+			t.Errorf("idleExpired(%v, %d) = %v, want %v", tt.now.Sub(last), tt.minutes, got, tt.want)
+		}
+	}
+}
+
+func TestDueWithin(t *testing.T) {
+	now := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time { due := now.Add(d); return &due }
+	tests := []struct {
+		name string
+		todo Todo
+		want bool
+	}{
+		{"no due date", Todo{}, false},
+		{"due soon", Todo{Due: at(30 * time.Minute)}, true},
+		{"due at the window end", Todo{Due: at(time.Hour)}, true},
+		{"due later", Todo{Due: at(2 * time.Hour)}, false},
+		{"already overdue", Todo{Due: at(-time.Minute)}, false},
+		{"done", Todo{Due: at(30 * time.Minute), Done: true}, false},
+	}
+	for _, tt := range tests {
+		if got := dueWithin([]Todo{tt.todo}, now, time.Hour); got != tt.want {
+			t.Errorf("%s: dueWithin = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestListSideFile(t *testing.T) {
+	dataDir = "/data"
+	t.Cleanup(func() { dataDir = "" })
+	tests := []struct {
+		list, base, want string
+	}{
+		{"", "trash", "/data/trash.json"},
+		{"", "done", "/data/done.json"},
+		{"工作", "trash", "/data/trash-工作.json"},
+		{"工作", "stats", "/data/stats-工作.json"},
+	}
+	for _, tt := range tests {
+		if got := listSideFile(tt.list, tt.base); got != filepath.FromSlash(tt.want) {
+			t.Errorf("listSideFile(%q, %q) = %q, want %q", tt.list, tt.base, got, tt.want)
+		}
+	}
+}
+
+func TestMergeConfig(t *testing.T) {
+	cur := defaultConfig()
+	cur.List = "工作"
+	cur.Encrypt = true
+	cur.Focus = "写报告"
+	cur.Hooks = map[string]string{"on_add": "notify-send", "on_done": "true"}
+	cur.Abbreviations = map[string]string{"brb": "be right back"}
+
+	full, err := json.Marshal(cur)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		data    string
+		want    func(c *Config)
+		wantErr bool
+	}{
+		{"get/set round trip", string(full), func(c *Config) {}, false},
+		{"unset keys are kept", `{"theme": "dark"}`, func(c *Config) { c.Theme = "dark" }, false},
+		{"maps are replaced", `{"hooks": {"on_done": "echo"}}`, func(c *Config) { c.Hooks = map[string]string{"on_done": "echo"} }, false},
+		{"maps can be cleared", `{"abbreviations": null}`, func(c *Config) { c.Abbreviations = nil }, false},
+		{"invalid theme", `{"theme": "blue"}`, nil, true},
+		{"invalid merged hook", `{"hooks": {"on_boot": "reboot"}}`, nil, true},
+		{"invalid list name", `{"list": "../x"}`, nil, true},
+		{"negative backups", `{"backups": -1}`, nil, true},
+		{"wrong type", `{"backups": "5"}`, nil, true},
+		{"not an object", `[1]`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := mergeConfig(cur, []byte(tt.data))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: mergeConfig succeeded, want an error", tt.name)
+			}
+			if !reflect.DeepEqual(got, cur) {
+				t.Errorf("%s: rejected config = %+v, want the current config", tt.name, got)
+			}
+			continue
+		}
+		want := cur
+		want.Hooks = maps.Clone(cur.Hooks)
+		want.Abbreviations = maps.Clone(cur.Abbreviations)
+		tt.want(&want)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: mergeConfig = %+v, %v, want %+v", tt.name, got, err, want)
+		}
+	}
+	if len(cur.Hooks) != 2 {
+		t.Errorf("mergeConfig modified the current config's hooks: %v", cur.Hooks)
+	}
+}
+
+func TestCompletionStreak(t *testing.T) {
+	now := time.Date(2026, 3, 4, 20, 0, 0, 0, time.Local)
+	day := func(offset int) string { return now.AddDate(0, 0, offset).Format("2006-01-02") }
+	tests := []struct {
+		name string
+		days []int
+		want int
+	}{
+		{"no completions", nil, 0},
+		{"today only", []int{0}, 1},
+		{"today not done yet", []int{-1, -2}, 2},
+		{"broken streak", []int{0, -1, -3}, 2},
+		{"stale", []int{-2, -3}, 0},
+		{"across a month boundary", []int{0, -1, -2, -3, -4}, 5},
+	}
+	for _, tt := range tests {
+		c := completionLog{Days: map[string]int{}}
+		for _, d := range tt.days {
+			c.Days[day(d)] = 1
+		}
+		if got := c.streak(now); got != tt.want {
+			t.Errorf("%s: streak = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCompletionUnrecord(t *testing.T) {
+	now := time.Date(2026, 3, 4, 20, 0, 0, 0, time.Local)
+	c := completionLog{Days: map[string]int{}}
+	c.record(now)
+	c.record(now)
+	c.unrecord(now)
+	if got := c.Days["2026-03-04"]; got != 1 {
+		t.Fatalf("count after record, record, unrecord = %d, want 1", got)
+	}
+	c.unrecord(now)
+	c.unrecord(now)
+	if _, ok := c.Days["2026-03-04"]; ok || c.streak(now) != 0 {
+		t.Fatalf("day still recorded after toggling back: %v", c.Days)
+	}
+}
+
+func TestExtractTags(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"买菜", nil},
+		{"买菜 #家庭", []string{"家庭"}},
+		{"#工作 写周报", []string{"工作"}},
+		{"全角 ＃学习 标签", []string{"学习"}},
+		{"带标点 #工作，明天交", []string{"工作"}},
+		{"句末 #work.", []string{"work"}},
+		{"#a-b_c! 连字符和下划线", []string{"a-b_c"}},
+		{"去重 #工作 #家庭 #工作 ＃家庭", []string{"工作", "家庭"}},
+		{"不在词首的 a#b 和 # 空标签", nil},
+		{"#123 数字", []string{"123"}},
+	}
+	for _, tt := range tests {
+		if got := extractTags(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("extractTags(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeTodosFillsTags(t *testing.T) {
+	todos := []Todo{
+		{ID: "a", Text: "旧文件 #工作", Subtasks: []Todo{{ID: "b", Text: "子任务 #家庭"}}},
+		{ID: "c", Text: "已有标签 #工作", Tags: []string{"工作"}},
+		{ID: "d", Text: "没有标签"},
+	}
+	if !normalizeTodos(todos) {
+		t.Fatal("normalizeTodos reported no change")
+	}
+	if !reflect.DeepEqual(todos[0].Tags, []string{"工作"}) || !reflect.DeepEqual(todos[0].Subtasks[0].Tags, []string{"家庭"}) {
+		t.Errorf("tags were not filled in: %+v", todos[0])
+	}
+	if todos[2].Tags != nil {
+		t.Errorf("untagged todo got tags %q", todos[2].Tags)
+	}
+	if normalizeTodos(todos) {
+		t.Error("second normalizeTodos reported a change")
+	}
+}
+
+func TestParseDue(t *testing.T) {
+	monday := time.Date(2026, 3, 2, 10, 0, 0, 0, time.Local)
+	sundayNight := time.Date(2026, 3, 8, 23, 30, 0, 0, time.Local)
+	newYearsEve := time.Date(2026, 12, 31, 9, 0, 0, 0, time.Local)
+	tests := []struct {
+		name string
+		now  time.Time
+		text string
+		want string // 截止日期，空表示无法识别
+		rest string
+	}{
+		{"today is Monday", monday, "周一 开会", "2026-03-02", "开会"},
+		{"next day", monday, "周二 开会", "2026-03-03", "开会"},
+		{"Sunday ends the week", monday, "周日 休息", "2026-03-08", "休息"},
+		{"星期天", monday, "星期天 休息", "2026-03-08", "休息"},
+		{"today is Sunday", sundayNight, "周日 复盘", "2026-03-08", "复盘"},
+		{"Monday after Sunday", sundayNight, "周一 开会", "2026-03-09", "开会"},
+		{"今天 late at night", sundayNight, "今天 睡觉", "2026-03-08", "睡觉"},
+		{"明天 crosses the week", sundayNight, "明天 买菜", "2026-03-09", "买菜"},
+		{"明天 crosses the year", newYearsEve, "明天 拜年", "2027-01-01", "拜年"},
+		{"后天 crosses the year", newYearsEve, "后天 上班", "2027-01-02", "上班"},
+		{"weekday in the new year", newYearsEve, "周三 开会", "2027-01-06", "开会"},
+		{"weekday today at year end", newYearsEve, "周四 总结", "2026-12-31", "总结"},
+		{"unknown weekday", monday, "周八 开会", "", "周八 开会"},
+		{"keyword only", monday, "明天", "", "明天"},
+		{"no space after the keyword", monday, "明天买菜", "", "明天买菜"},
+		{"keyword not at the start", monday, "买菜 明天", "", "买菜 明天"},
+	}
+	for _, tt := range tests {
+		due, rest, ok := parseDue(tt.text, tt.now)
+		if tt.want == "" {
+			if ok || rest != tt.rest {
+				t.Errorf("%s: parseDue(%q) = %v, %q, %v, want no match", tt.name, tt.text, due, rest, ok)
+			}
+			continue
+		}
+		if !ok || due.Format("2006-01-02 15:04:05") != tt.want+" 23:59:59" || rest != tt.rest {
+			t.Errorf("%s: parseDue(%q) = %v, %q, %v, want %s 23:59:59, %q", tt.name, tt.text, due, rest, ok, tt.want, tt.rest)
+		}
+	}
+}
+
+func TestFuzzyScoreOrder(t *testing.T) {
+	todos := []Todo{
+		{Text: "b r e a d"},      // 字符分散
+		{Text: "buy bread"},      // 整词出现
+		{Text: "bake red bread"}, // 整词出现但不在开头
+		{Text: "breakfast"},      // 前缀连续命中
+		{Text: "milk"},           // 不匹配
+	}
+	order := []int{0, 1, 2, 3, 4}
+	tests := []struct {
+		pattern string
+		want    []int
+	}{
+		{"bread", []int{1, 2, 0}},
+		// 都在词首整词命中，分数相同时保持原来的顺序
+		{"brea", []int{1, 2, 3, 0}},
+		{"BREAD buy", []int{1}},
+		{"xyz", nil},
+	}
+	for _, tt := range tests {
+		if got := filterOrder(todos, order, tt.pattern, true); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("filterOrder(%q) = %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+
+	// 中文按单个字符匹配，间隔越小越靠前
+	cjk := []Todo{{Text: "写一份报告"}, {Text: "写周报"}, {Text: "周末报名"}}
+	if got := filterOrder(cjk, []int{0, 1, 2}, "写报", true); !reflect.DeepEqual(got, []int{1, 0}) {
+		t.Errorf("filterOrder(写报) = %v, want [1 0]", got)
+	}
+}
+
+func TestDisplayOrder(t *testing.T) {
+	now := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	past := now.Add(-time.Hour)
+	todos := []Todo{
+		{Text: "normal b"},
+		{Text: "urgent", Priority: priorityUrgent},
+		{Text: "overdue", Due: &past},
+		{Text: "pinned", Pinned: true},
+		{Text: "high", Priority: priorityHigh},
+		{Text: "normal a"},
+		{Text: "overdue done", Due: &past, Done: true},
+	}
+	tests := []struct {
+		name           string
+		alpha, reverse bool
+		want           []int
+	}{
+		{"insertion order", false, false, []int{3, 2, 1, 4, 0, 5, 6}},
+		{"reversed", false, true, []int{3, 2, 1, 4, 6, 5, 0}},
+		{"alphabetical", true, false, []int{3, 2, 1, 4, 5, 0, 6}},
+	}
+	for _, tt := range tests {
+		if got := displayOrder(todos, now, tt.alpha, tt.reverse); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: displayOrder = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetWeight(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want int
+	}{
+		{"ASCII", "abc", 3},
+		{"Chinese", "中文", 4},
+		{"kana", "かなカナ", 8},
+		{"Hangul", "한국어", 6},
+		{"fullwidth forms", "ＡＢ１", 6},
+		{"mixed Japanese, Korean and Latin", "日本語abc한", 11},
+		{"ZWJ family emoji", "👨‍👩‍👧", 2},
+		{"flag", "🇨🇳", 2},
+		{"emoji with skin tone", "👍🏽", 2},
+		{"combining acute accent", "cafe\u0301", 4},
+		{"stacked combining marks", "a\u0300\u0316", 1},
+		{"CRLF is one cluster", "a\r\nb", 3},
+		{"empty", "", 0},
+	}
+	for _, tt := range tests {
+		if got := getWeight(tt.s, weightModeMixed); got != tt.want {
+			t.Errorf("%s: getWeight(%q) = %d, want %d", tt.name, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateByWeight(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		maxW int
+		want string
+	}{
+		{"fits", "abc", 3, "abc"},
+		{"wide character does not fit", "中文abc", 3, "中"},
+		{"emoji is not split", "a👨‍👩‍👧b", 2, "a"},
+		{"emoji fits", "a👨‍👩‍👧b", 3, "a👨‍👩‍👧"},
+		{"flag is not split", "🇨🇳🇯🇵", 3, "🇨🇳"},
+		{"combining mark stays with its letter", "cafe\u0301!", 4, "cafe\u0301"},
+		{"CRLF is kept whole", "a\r\nb", 2, "a\r\n"},
+		{"CRLF is not split", "a\r\nb", 1, "a"},
+		{"zero", "abc", 0, ""},
+	}
+	for _, tt := range tests {
+		if got := truncateByWeight(tt.s, tt.maxW, weightModeMixed); got != tt.want {
+			t.Errorf("%s: truncateByWeight(%q, %d) = %q, want %q", tt.name, tt.s, tt.maxW, got, tt.want)
+		}
+	}
+
+	ellipsis := []struct {
+		s    string
+		maxW int
+		want string
+	}{
+		{"中文abc", 4, "中文…"},
+		{"中文abc", 7, "中文abc"},
+		{"👨‍👩‍👧👨‍👩‍👧", 3, "👨‍👩‍👧…"},
+	}
+	for _, tt := range ellipsis {
+		if got := truncateByWeightWithEllipsis(tt.s, tt.maxW, weightModeMixed); got != tt.want {
+			t.Errorf("truncateByWeightWithEllipsis(%q, %d) = %q, want %q", tt.s, tt.maxW, got, tt.want)
+		}
+	}
+}
+
+func TestExpandAbbreviations(t *testing.T) {
+	abbr := map[string]string{"brb": "be right back", "会": "会议", "ty": "thank you"}
+	tests := []struct {
+		name, text, want string
+	}{
+		{"whole word", "brb", "be right back"},
+		{"among other words", "ok brb soon", "ok be right back soon"},
+		{"several words", "brb ty", "be right back thank you"},
+		{"substring is kept", "brbx xbrb abrbc", "brbx xbrb abrbc"},
+		{"case sensitive", "BRB Brb", "BRB Brb"},
+		{"punctuation makes a different word", "brb,", "brb,"},
+		{"whitespace is preserved", "  brb\tty\n", "  be right back\tthank you\n"},
+		{"Chinese", "开 会", "开 会议"},
+		{"Chinese substring is kept", "开会", "开会"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		if got := expandAbbreviations(tt.text, abbr); got != tt.want {
+			t.Errorf("%s: expandAbbreviations(%q) = %q, want %q", tt.name, tt.text, got, tt.want)
+		}
+	}
+	if got := expandAbbreviations("brb", nil); got != "brb" {
+		t.Errorf("expandAbbreviations without abbreviations = %q", got)
+	}
+}
+
+func TestSplitLastToken(t *testing.T) {
+	tests := []struct {
+		s, prefix, token string
+	}{
+		{"ok brb", "ok ", "brb"},
+		{"brb", "", "brb"},
+		{"ok ", "ok ", ""},
+		{"开　会", "开　", "会"},
+	}
+	for _, tt := range tests {
+		if prefix, token := splitLastToken(tt.s); prefix != tt.prefix || token != tt.token {
+			t.Errorf("splitLastToken(%q) = %q, %q, want %q, %q", tt.s, prefix, token, tt.prefix, tt.token)
+		}
+	}
+}
+
+func TestRunHookPayload(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks run through sh")
+	}
+	out := filepath.Join(t.TempDir(), "hook.out")
+	config.Hooks = map[string]string{
+		"on_add": `{ printf '%s|' "$1"; cat; } > '` + out + `.tmp' && mv '` + out + `.tmp' '` + out + `'`,
+	}
+	t.Cleanup(func() { config = Config{} })
+
+	runHook("on_done", Todo{Text: "未配置的事件"})
+	runHook("on_add", Todo{Text: "买菜 $HOME `x` 'y'"})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, err := os.ReadFile(out)
+		if err == nil {
+			if got, want := string(data), "买菜 $HOME `x` 'y'|买菜 $HOME `x` 'y'\n"; got != want {
+				t.Fatalf("hook payload = %q, want %q", got, want)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("hook did not run: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestWeightModes(t *testing.T) {
+	tests := []struct {
+		s                      string
+		mixed, uniform         int
+		cutMixed, cutUniform   string // 截断到权重 4
+		tipsMixed, tipsUniform string // 上限为 10 时的输入提示
+	}{
+		{"写周报", 6, 3, "写周", "写周报", "字数 3 / 余 4", "字数 3 / 余 7"},
+		{"abc", 3, 3, "abc", "abc", "字数 3 / 余 7", "字数 3 / 余 7"},
+		{"买菜ab", 6, 4, "买菜", "买菜ab", "字数 4 / 余 4", "字数 4 / 余 6"},
+		{"👨‍👩‍👧かな", 6, 3, "👨‍👩‍👧か", "👨‍👩‍👧かな", "字数 3 / 余 4", "字数 3 / 余 7"},
+		{"一二三四五", 10, 5, "一二", "一二三四", "字数 5 / 余 0", "字数 5 / 余 5"},
+	}
+	for _, tt := range tests {
+		if got := getWeight(tt.s, weightModeMixed); got != tt.mixed {
+			t.Errorf("getWeight(%q, mixed) = %d, want %d", tt.s, got, tt.mixed)
+		}
+		if got := getWeight(tt.s, weightModeUniform); got != tt.uniform {
+			t.Errorf("getWeight(%q, uniform) = %d, want %d", tt.s, got, tt.uniform)
+		}
+		if got := truncateByWeight(tt.s, 4, weightModeMixed); got != tt.cutMixed {
+			t.Errorf("truncateByWeight(%q, 4, mixed) = %q, want %q", tt.s, got, tt.cutMixed)
+		}
+		if got := truncateByWeight(tt.s, 4, weightModeUniform); got != tt.cutUniform {
+			t.Errorf("truncateByWeight(%q, 4, uniform) = %q, want %q", tt.s, got, tt.cutUniform)
+		}
+		if got := inputTips(tt.s, 10, weightModeMixed); got != tt.tipsMixed {
+			t.Errorf("inputTips(%q, mixed) = %q, want %q", tt.s, got, tt.tipsMixed)
+		}
+		if got := inputTips(tt.s, 10, weightModeUniform); got != tt.tipsUniform {
+			t.Errorf("inputTips(%q, uniform) = %q, want %q", tt.s, got, tt.tipsUniform)
+		}
+	}
+	// 未设置时按 mixed 计算
+	if got := getWeight("写周报", ""); got != 6 {
+		t.Errorf(`getWeight with mode "" = %d, want 6`, got)
+	}
+}
+
+func TestFocusPersistsAndClears(t *testing.T) {
+	configFile = filepath.Join(t.TempDir(), "config.json")
+	t.Cleanup(func() { configFile = "" })
+
+	cfg := defaultConfig()
+	cfg.Focus = "写报告\n第二行"
+	if err := saveConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	loaded := loadConfig()
+	if loaded.Focus != cfg.Focus {
+		t.Fatalf("focus after reload = %q, want %q", loaded.Focus, cfg.Focus)
+	}
+	if got := loaded.focusHeader(); got != "正在专注: 写报告 第二行" {
+		t.Errorf("focusHeader = %q", got)
+	}
+
+	loaded.Focus = ""
+	if err := saveConfig(loaded); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(`"focus"`)) {
+		t.Errorf("cleared focus is still written: %s", data)
+	}
+	if cleared := loadConfig(); cleared.Focus != "" || cleared.focusHeader() != "" {
+		t.Errorf("focus after clearing = %q, header %q", cleared.Focus, cleared.focusHeader())
+	}
+}
+
+func TestTrayHeader(t *testing.T) {
+	tests := []struct {
+		compact    bool
+		count      int
+		addLabel   string
+		separator  bool
+		emptyLabel string
+	}{
+		{false, 0, "➕ 新增待办", true, "（暂无待办）"},
+		{false, 5, "➕ 新增待办", true, "（暂无待办）"},
+		{true, 0, "➕ 新增 · 0 待办", false, ""},
+		{true, 5, "➕ 新增 · 5 待办", false, ""},
+	}
+	for _, tt := range tests {
+		addLabel, separator, emptyLabel := trayHeader(tt.compact, tt.count)
+		if addLabel != tt.addLabel || separator != tt.separator || emptyLabel != tt.emptyLabel {
+			t.Errorf("trayHeader(%v, %d) = %q, %v, %q, want %q, %v, %q", tt.compact, tt.count, addLabel, separator, emptyLabel, tt.addLabel, tt.separator, tt.emptyLabel)
+		}
+	}
+}
+
+func TestDesktopExecQuote(t *testing.T) {
+	tests := []struct {
+		exe  string
+		want string
+	}{
+		{"/usr/bin/mytodo", `"/usr/bin/mytodo"`},
+		{"/opt/my todo/mytodo", `"/opt/my todo/mytodo"`},
+		{"/opt/100%/mytodo", `"/opt/100%%/mytodo"`},
+		{`/opt/a\b/mytodo`, `"/opt/a\\\\b/mytodo"`},
+		{`/opt/"x"/mytodo`, `"/opt/\\"x\\"/mytodo"`},
+		{"/opt/$HOME/mytodo", `"/opt/\\$HOME/mytodo"`},
+		{"/opt/`id`/mytodo", "\"/opt/\\\\`id\\\\`/mytodo\""},
+	}
+	for _, tt := range tests {
+		if got := desktopExecQuote(tt.exe); got != tt.want {
+			t.Errorf("desktopExecQuote(%q) = %s, want %s", tt.exe, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Store 是待办列表的存储接口，UI 回调、socket 与 HTTP 处理器都通过它读写
+// 文件实现见 fileStore，memoryStore 只保存在内存中，可用于测试
+type Store interface {
+	// All 返回当前待办的深拷贝，调用方可以不加锁地读写
+	All() []Todo
+	// Add 追加待办
+	Add(todos ...Todo)
+	// Insert 在下标 idx 处插入待办，idx 超出范围时追加到末尾
+	Insert(idx int, t Todo)
+	// Update 在锁内对指定 ID 的待办（也可以是任意一级的子任务）执行 fn，返回修改后的深拷贝
+	Update(id string, fn func(t *Todo)) (Todo, bool)
+	// Delete 删除指定 ID 的待办，返回被删除的项及其原来的下标，找不到时下标为 -1
+	Delete(id string) (Todo, int)
+	// Swap 交换 ID 为 a、b 的两条待办在列表中的位置，任一不存在时返回 false
+	Swap(a, b string) bool
+	// Clear 清空所有待办，返回清空前的列表
+	Clear() []Todo
+	// Replace 用 todos 替换全部待办
+	Replace(todos []Todo)
+	// RemoveDone 移除所有已完成的待办并返回它们
+	RemoveDone() []Todo
+	// Sort 按 less 对待办进行稳定排序
+	Sort(less func(a, b Todo) bool)
+	// Load 从存储重新读取全部待办
+	Load() error
+	// Save 持久化当前待办
+	Save() error
+	// SwitchFile 保存当前待办后改用 path 作为数据文件并从中加载
+	SwitchFile(path string) error
+}
+
+// memoryStore 用互斥锁保护内存中的待办列表，Load、Save 和 SwitchFile 不做任何事
+type memoryStore struct {
+	mu    sync.Mutex
+	todos []Todo
+}
+
+// cloneTodos 返回 todos 的深拷贝，nil 仍为 nil
+func cloneTodos(todos []Todo) []Todo {
+	if todos == nil {
+		return nil
+	}
+	c := make([]Todo, len(todos))
+	for i, t := range todos {
+		c[i] = cloneTodo(t)
+	}
+	return c
+}
+
+// cloneTodo 复制待办及其标签、时间和各级子任务，返回的副本与 t 不共享任何底层数组
+func cloneTodo(t Todo) Todo {
+	t.Tags = slices.Clone(t.Tags)
+	t.Subtasks = cloneTodos(t.Subtasks)
+	if t.DoneAt != nil {
+		d := *t.DoneAt
+		t.DoneAt = &d
+	}
+	if t.Due != nil {
+		d := *t.Due
+		t.Due = &d
+	}
+	return t
+}
+
+func (s *memoryStore) All() []Todo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return cloneTodos(s.todos)
+}
+
+func (s *memoryStore) Add(todos ...Todo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.todos = append(s.todos, todos...)
+}
+
+func (s *memoryStore) Delete(id string) (Todo, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx := indexByID(s.todos, id)
+	if idx < 0 {
+		return Todo{}, -1
+	}
+	t := cloneTodo(s.todos[idx])
+	s.todos = append(s.todos[:idx], s.todos[idx+1:]...)
+	return t, idx
+}
+
+func (s *memoryStore) Insert(idx int, t Todo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if idx < 0 || idx > len(s.todos) {
+		idx = len(s.todos)
+	}
+	s.todos = append(s.todos[:idx], append([]Todo{t}, s.todos[idx:]...)...)
+}
+
+func (s *memoryStore) Update(id string, fn func(t *Todo)) (Todo, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := findByID(s.todos, id)
+	if t == nil {
+		return Todo{}, false
+	}
+	fn(t)
+	return cloneTodo(*t), true
+}
+
+func (s *memoryStore) Swap(a, b string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, j := indexByID(s.todos, a), indexByID(s.todos, b)
+	if i < 0 || j < 0 {
+		return false
+	}
+	s.todos[i], s.todos[j] = s.todos[j], s.todos[i]
+	return true
+}
+
+func (s *memoryStore) Clear() []Todo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := cloneTodos(s.todos)
+	s.todos = nil
+	return old
+}
+
+func (s *memoryStore) Replace(todos []Todo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.todos = todos
+}
+
+func (s *memoryStore) RemoveDone() []Todo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var done, open []Todo
+	for _, t := range s.todos {
+		if t.Done {
+			done = append(done, cloneTodo(t))
+		} else {
+			open = append(open, t)
+		}
+	}
+	s.todos = open
+	return done
+}
+
+func (s *memoryStore) Sort(less func(a, b Todo) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sort.SliceStable(s.todos, func(i, j int) bool {
+		return less(s.todos[i], s.todos[j])
+	})
+}
+
+func (s *memoryStore) Load() error                  { return nil }
+func (s *memoryStore) Save() error                  { return nil }
+func (s *memoryStore) SwitchFile(path string) error { return nil }
+
+// todoCodec 负责待办列表与数据文件内容之间的转换
+type todoCodec interface {
+	// Encode 把待办编码为要写入文件的内容
+	Encode(todos []Todo) ([]byte, error)
+	// Decode 解析文件内容，旧格式被升级时 migrated 为 true
+	Decode(data []byte) (todos []Todo, migrated bool, err error)
+}
+
+// fileCodec 使用当前版本的 todo.json 格式，开启加密时加解密内容（见 encodeTodos、migrateData）
+type fileCodec struct{}
+
+func (fileCodec) Encode(todos []Todo) ([]byte, error) { return encodeTodos(todos) }
+
+func (fileCodec) Decode(data []byte) ([]Todo, bool, error) { return migrateData(data) }
+
+// fileStore 在 memoryStore 的基础上用 codec 读写数据文件 path
+// 保存前按 backups() 轮换备份；synced 为最后一次读取或写入的文件内容，Reload 据此忽略自身的写入
+// unsupported 不为 nil 时数据文件是本程序不支持的格式（见 errUnsupportedData），保存会返回该错误
+type fileStore struct {
+	memoryStore
+	path        string
+	codec       todoCodec
+	backups     func() int
+	synced      []byte
+	unsupported error
+}
+
+// newFileStore 创建读写 path 的 fileStore，待办需调用 Load 加载
+func newFileStore(path string, codec todoCodec, backups func() int) *fileStore {
+	return &fileStore{path: path, codec: codec, backups: backups}
+}
+
+// Path 返回当前数据文件的路径
+func (s *fileStore) Path() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.path
+}
+
+// Load 重新读取数据文件；加密数据无法用当前口令解密时返回包装了 errLocked 的错误，此时待办为空
+func (s *fileStore) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	todos, data, migrated, err := s.read(s.path)
+	s.todos, s.synced = todos, data
+	s.unsupported = nil
+	if errors.Is(err, errUnsupportedData) {
+		s.unsupported = err
+	}
+	if err != nil {
+		return err
+	}
+	if dataLocked() {
+		return errLocked
+	}
+	// 迁移过格式或补充过 ID 时立即以新格式保存
+	if migrated {
+		if err := s.save(); err != nil {
+			log.Printf("Error saving migrated todo file: %v", err)
+		}
+	}
+	return nil
+}
+
+// Reload 在数据文件被外部修改后重新加载，内容与最后一次读写的相同时不做任何事，返回是否重新加载
+// 无法解析的内容（例如写到一半的文件）返回错误并保留当前的待办
+func (s *fileStore) Reload() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		// 以重命名方式替换文件的中途
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(data, s.synced) {
+		return false, nil
+	}
+	todos, _, err := s.codec.Decode(data)
+	if errors.Is(err, errUnsupportedData) {
+		// 文件被更新版本的程序改写，此后不再覆盖它
+		s.unsupported = err
+	}
+	if err != nil {
+		return false, err
+	}
+	s.unsupported = nil
+	// 补充的 ID 和标签在下次保存时写入文件
+	normalizeTodos(todos)
+	s.todos, s.synced = todos, data
+	return true, nil
+}
+
+// Save 将当前待办写入数据文件，写入期间持有锁以免并发写文件
+func (s *fileStore) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save()
+}
+
+// SwitchFile 整个过程持有锁，避免并发的保存把旧列表写进新文件
+// 新文件无法读取时仍使用原来的文件和待办
+func (s *fileStore) SwitchFile(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.save(); err != nil {
+		return err
+	}
+	todos, data, _, err := s.read(path)
+	if err != nil {
+		return err
+	}
+	s.path, s.todos, s.synced, s.unsupported = path, todos, data, nil
+	return nil
+}
+
+// read 读取并解析 path，文件不存在时返回空列表，调用方需持有锁
+// 无法解析的文件改名为 .corrupt-<时间> 保留，避免下次保存时被空列表覆盖；无法解密或格式不受支持的文件保持原样
+func (s *fileStore) read(path string) (todos []Todo, data []byte, migrated bool, err error) {
+	data, err = os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return []Todo{}, nil, false, nil
+	}
+	if err != nil {
+		log.Printf("Error reading todo file: %v", err)
+		return []Todo{}, nil, false, err
+	}
+	todos, migrated, err = s.codec.Decode(data)
+	if errors.Is(err, errLocked) {
+		log.Printf("Cannot decrypt todo file %s: %v", path, err)
+		return []Todo{}, data, false, err
+	}
+	if errors.Is(err, errUnsupportedData) {
+		log.Printf("Cannot read todo file %s: %v", path, err)
+		return []Todo{}, data, false, err
+	}
+	if err != nil {
+		log.Printf("Error unmarshalling todo data: %v", err)
+		backup := path + ".corrupt-" + time.Now().Format("20060102-150405")
+		if err := os.Rename(path, backup); err != nil {
+			log.Printf("Error backing up corrupt todo file: %v", err)
+		} else {
+			log.Printf("Corrupt todo file moved to %s", backup)
+		}
+		return []Todo{}, nil, false, nil
+	}
+	// 为旧文件或手动编辑的文件中的待办和子任务补充 ID 和标签
+	if normalizeTodos(todos) {
+		migrated = true
+	}
+	return todos, data, migrated, nil
+}
+
+// save 备份并写入数据文件，调用方需持有锁
+func (s *fileStore) save() error {
+	if s.unsupported != nil {
+		return s.unsupported
+	}
+	normalizeTodos(s.todos)
+	data, err := s.codec.Encode(s.todos)
+	if err != nil {
+		log.Printf("Error marshalling todo data: %v", err)
+		return err
+	}
+	// 备份失败不影响保存
+	if err := rotateBackups(s.path, s.backups()); err != nil {
+		log.Printf("Error rotating todo backups: %v", err)
+	}
+	if err := writeFileRetry(s.path, data, 0600); err != nil {
+		log.Printf("Error writing todo file: %v", err)
+		return err
+	}
+	// WriteFile 只在创建时应用权限，旧版本留下的 0644 文件在这里收紧
+	if err := os.Chmod(s.path, 0600); err != nil {
+		log.Printf("Error restricting todo file permissions: %v", err)
+	}
+	s.synced = data
+	debugf("Saved %d todos to %s", len(s.todos), s.path)
+	return nil
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// todoTexts 返回待办的文本，已完成的以 "✓" 开头
+func todoTexts(todos []Todo) []string {
+	texts := []string{}
+	for _, t := range todos {
+		if t.Done {
+			texts = append(texts, "✓"+t.Text)
+		} else {
+			texts = append(texts, t.Text)
+		}
+	}
+	return texts
+}
+
+func newTestStore(t *testing.T, path string) *fileStore {
+	t.Helper()
+	s := newFileStore(path, fileCodec{}, func() int { return 1 })
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return s
+}
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		op   func(s *fileStore)
+		want []string
+	}{
+		{"add", func(s *fileStore) { s.Add(Todo{ID: "d", Text: "丁"}) }, []string{"甲", "乙", "丙", "丁"}},
+		{"update", func(s *fileStore) { s.Update("b", func(t *Todo) { t.Text, t.Done = "乙2", true }) }, []string{"甲", "✓乙2", "丙"}},
+		{"update missing", func(s *fileStore) { s.Update("x", func(t *Todo) { t.Text = "x" }) }, []string{"甲", "乙", "丙"}},
+		{"remove", func(s *fileStore) { s.Delete("a") }, []string{"乙", "丙"}},
+		{"remove missing", func(s *fileStore) { s.Delete("x") }, []string{"甲", "乙", "丙"}},
+		{"replace", func(s *fileStore) { s.Replace([]Todo{{ID: "z", Text: "新"}}) }, []string{"新"}},
+		{"replace empty", func(s *fileStore) { s.Replace(nil) }, []string{}},
+		{"remove done", func(s *fileStore) {
+			s.Update("c", func(t *Todo) { t.Done = true })
+			s.RemoveDone()
+		}, []string{"甲", "乙"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "todo.json")
+			s := newTestStore(t, path)
+			s.Add(Todo{ID: "a", Text: "甲"}, Todo{ID: "b", Text: "乙"}, Todo{ID: "c", Text: "丙"})
+			if err := s.Save(); err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+			tt.op(s)
+			if err := s.Save(); err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+			if got := todoTexts(newTestStore(t, path).All()); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("reloaded todos = %q, want %q", got, tt.want)
+			}
+			if _, err := os.Stat(path + ".1"); err != nil {
+				t.Errorf("backup of the previous save is missing: %v", err)
+			}
+		})
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	tests := []struct {
+		name string
+		op   func(t *testing.T, s *memoryStore)
+		want []string
+	}{
+		{"add nothing", func(t *testing.T, s *memoryStore) { s.Add() }, []string{"甲", "乙", "丙"}},
+		{"add several", func(t *testing.T, s *memoryStore) {
+			s.Add(Todo{ID: "d", Text: "丁"}, Todo{ID: "e", Text: "戊"})
+		}, []string{"甲", "乙", "丙", "丁", "戊"}},
+		{"delete first", func(t *testing.T, s *memoryStore) {
+			if td, idx := s.Delete("a"); td.Text != "甲" || idx != 0 {
+				t.Errorf("Delete = %q, %d, want 甲, 0", td.Text, idx)
+			}
+		}, []string{"乙", "丙"}},
+		{"delete last", func(t *testing.T, s *memoryStore) {
+			if td, idx := s.Delete("c"); td.Text != "丙" || idx != 2 {
+				t.Errorf("Delete = %q, %d, want 丙, 2", td.Text, idx)
+			}
+		}, []string{"甲", "乙"}},
+		{"delete missing", func(t *testing.T, s *memoryStore) {
+			if _, idx := s.Delete("x"); idx != -1 {
+				t.Errorf("Delete index = %d, want -1", idx)
+			}
+		}, []string{"甲", "乙", "丙"}},
+		{"delete only matches top level", func(t *testing.T, s *memoryStore) {
+			if _, idx := s.Delete("b1"); idx != -1 {
+				t.Errorf("Delete index = %d, want -1", idx)
+			}
+		}, []string{"甲", "乙", "丙"}},
+		{"insert at start", func(t *testing.T, s *memoryStore) { s.Insert(0, Todo{ID: "d", Text: "丁"}) }, []string{"丁", "甲", "乙", "丙"}},
+		{"insert at end", func(t *testing.T, s *memoryStore) { s.Insert(3, Todo{ID: "d", Text: "丁"}) }, []string{"甲", "乙", "丙", "丁"}},
+		{"insert negative", func(t *testing.T, s *memoryStore) { s.Insert(-1, Todo{ID: "d", Text: "丁"}) }, []string{"甲", "乙", "丙", "丁"}},
+		{"insert past end", func(t *testing.T, s *memoryStore) { s.Insert(99, Todo{ID: "d", Text: "丁"}) }, []string{"甲", "乙", "丙", "丁"}},
+		{"update nested", func(t *testing.T, s *memoryStore) {
+			got, ok := s.Update("b1", func(t *Todo) { t.Done = true })
+			if !ok || got.Text != "子1" || !got.Done {
+				t.Errorf("Update = %+v, %v", got, ok)
+			}
+			if sub := findByID(s.All(), "b1"); sub == nil || !sub.Done {
+				t.Errorf("subtask after Update = %+v", sub)
+			}
+		}, []string{"甲", "乙", "丙"}},
+		{"update missing", func(t *testing.T, s *memoryStore) {
+			called := false
+			if got, ok := s.Update("x", func(t *Todo) { called = true }); ok || called || got.ID != "" {
+				t.Errorf("Update = %+v, %v, fn called %v", got, ok, called)
+			}
+		}, []string{"甲", "乙", "丙"}},
+		{"swap", func(t *testing.T, s *memoryStore) {
+			if !s.Swap("a", "c") {
+				t.Error("Swap = false")
+			}
+		}, []string{"丙", "乙", "甲"}},
+		{"swap same", func(t *testing.T, s *memoryStore) {
+			if !s.Swap("b", "b") {
+				t.Error("Swap = false")
+			}
+		}, []string{"甲", "乙", "丙"}},
+		{"swap missing", func(t *testing.T, s *memoryStore) {
+			if s.Swap("a", "x") {
+				t.Error("Swap = true")
+			}
+		}, []string{"甲", "乙", "丙"}},
+		{"remove done", func(t *testing.T, s *memoryStore) {
+			s.Update("b", func(t *Todo) { t.Done = true })
+			if got := todoTexts(s.RemoveDone()); !reflect.DeepEqual(got, []string{"✓乙"}) {
+				t.Errorf("RemoveDone = %q", got)
+			}
+		}, []string{"甲", "丙"}},
+		{"remove done none", func(t *testing.T, s *memoryStore) {
+			if got := s.RemoveDone(); len(got) != 0 {
+				t.Errorf("RemoveDone = %+v", got)
+			}
+		}, []string{"甲", "乙", "丙"}},
+		{"clear", func(t *testing.T, s *memoryStore) {
+			if got := todoTexts(s.Clear()); !reflect.DeepEqual(got, []string{"甲", "乙", "丙"}) {
+				t.Errorf("Clear = %q", got)
+			}
+		}, []string{}},
+		{"replace", func(t *testing.T, s *memoryStore) { s.Replace([]Todo{{ID: "z", Text: "新"}}) }, []string{"新"}},
+		{"replace empty", func(t *testing.T, s *memoryStore) { s.Replace(nil) }, []string{}},
+		{"sort is stable", func(t *testing.T, s *memoryStore) {
+			s.Sort(func(a, b Todo) bool { return a.Priority > b.Priority })
+		}, []string{"乙", "甲", "丙"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &memoryStore{}
+			s.Add(Todo{ID: "a", Text: "甲"}, Todo{ID: "b", Text: "乙", Priority: 1, Subtasks: []Todo{{ID: "b1", Text: "子1"}}}, Todo{ID: "c", Text: "丙"})
+			tt.op(t, s)
+			if got := todoTexts(s.All()); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("todos = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreReturnsCopies(t *testing.T) {
+	todo := func() Todo {
+		return Todo{ID: "a", Text: "甲 #工作", Tags: []string{"工作"}, Subtasks: []Todo{
+			{ID: "a1", Text: "子1", Subtasks: []Todo{{ID: "a11", Text: "孙1"}}},
+		}}
+	}
+	s := &memoryStore{}
+	s.Add(todo())
+	want := []Todo{todo()}
+
+	mutate := func(t *Todo) {
+		t.Tags[0] = "改"
+		t.Subtasks[0].Done = true
+		t.Subtasks[0].Subtasks[0].Text = "改"
+	}
+	all := s.All()
+	mutate(&all[0])
+	if updated, ok := s.Update("a", func(t *Todo) {}); ok {
+		mutate(&updated)
+	}
+	if sub, ok := s.Update("a1", func(t *Todo) {}); ok {
+		sub.Subtasks[0].Done = true
+	}
+	if got := s.All(); !reflect.DeepEqual(got, want) {
+		t.Errorf("store changed through a returned copy: %+v, want %+v", got, want)
+	}
+}
+
+func TestFileStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "todo.json")
+	s := newTestStore(t, path)
+	s.Add(Todo{ID: "a", Text: "甲"})
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := s.Reload(); changed || err != nil {
+		t.Fatalf("Reload after own save = %v, %v, want false, nil", changed, err)
+	}
+
+	other := newTestStore(t, path)
+	other.Add(Todo{ID: "b", Text: "乙"})
+	if err := other.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := s.Reload(); !changed || err != nil {
+		t.Fatalf("Reload after external save = %v, %v, want true, nil", changed, err)
+	}
+	if got := todoTexts(s.All()); !reflect.DeepEqual(got, []string{"甲", "乙"}) {
+		t.Errorf("todos after reload = %q", got)
+	}
+
+	// 写到一半的文件不会覆盖内存中的待办
+	if err := os.WriteFile(path, []byte(`{"version": 2, "todos": [`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := s.Reload(); changed || err == nil {
+		t.Fatalf("Reload of a truncated file = %v, %v, want false and an error", changed, err)
+	}
+	if got := todoTexts(s.All()); !reflect.DeepEqual(got, []string{"甲", "乙"}) {
+		t.Errorf("todos after a failed reload = %q", got)
+	}
+}
+
+func TestFileStoreReloadFillsIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "todo.json")
+	s := newTestStore(t, path)
+	external := `{"version": 2, "todos": [
+		{"id": "a", "text": "甲", "subtasks": [{"text": "子1"}, {"id": "a", "text": "子2"}]},
+		{"text": "手动添加"},
+		{"text": "手动添加"}
+	]}`
+	if err := os.WriteFile(path, []byte(external), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := s.Reload(); !changed || err != nil {
+		t.Fatalf("Reload = %v, %v", changed, err)
+	}
+	seen := map[string]bool{}
+	var check func(todos []Todo)
+	check = func(todos []Todo) {
+		for _, td := range todos {
+			if td.ID == "" || seen[td.ID] {
+				t.Errorf("todo %q has missing or duplicate ID %q", td.Text, td.ID)
+			}
+			seen[td.ID] = true
+			check(td.Subtasks)
+		}
+	}
+	check(s.All())
+	if findByID(s.All(), "a").Text != "甲" {
+		t.Error("existing ID was not kept")
+	}
+}
+
+func TestFileStoreUnsupportedData(t *testing.T) {
+	// 读取加密封装会记下数据已加密
+	t.Cleanup(func() {
+		keyMu.Lock()
+		dataEncrypted = false
+		keyMu.Unlock()
+	})
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"newer version", `{"version": 99, "todos": [{"id": "a", "text": "甲"}]}`},
+		{"unknown cipher", `{"version": 1, "cipher": "chacha20-poly1305", "kdf": "argon2id", "data": "AAAA"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "todo.json")
+			if err := os.WriteFile(path, []byte(tt.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+			s := newFileStore(path, fileCodec{}, func() int { return 0 })
+			if err := s.Load(); !errors.Is(err, errUnsupportedData) {
+				t.Fatalf("Load: err = %v, want errUnsupportedData", err)
+			}
+			s.Add(Todo{ID: "b", Text: "乙"})
+			if err := s.Save(); !errors.Is(err, errUnsupportedData) {
+				t.Errorf("Save: err = %v, want errUnsupportedData", err)
+			}
+			if data, err := os.ReadFile(path); err != nil || string(data) != tt.data {
+				t.Errorf("data file was renamed or overwritten: %q, %v", data, err)
+			}
+		})
+	}
+}
+
+func TestMigrateV1File(t *testing.T) {
+	v1 := `[
+  {
+    "id": "a",
+    "text": "写周报 #工作",
+    "done": true,
+    "done_at": "2026-03-02T18:30:00+08:00",
+    "due": "2026-03-06T09:00:00+08:00",
+    "priority": 2,
+    "created": "2026-03-01T08:00:00+08:00",
+    "repeat": "weekly",
+    "tags": ["工作"],
+    "pinned": true,
+    "subtasks": [{"id": "a1", "text": "收集数据", "created": "2026-03-01T08:05:00+08:00"}]
+  },
+  {"id": "b", "text": "买菜", "created": "0001-01-01T00:00:00Z"}
+]`
+	path := filepath.Join(t.TempDir(), "todo.json")
+	if err := os.WriteFile(path, []byte(v1), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var want []Todo
+	if err := json.Unmarshal([]byte(v1), &want); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestStore(t, path)
+	if got := s.All(); !reflect.DeepEqual(got, want) {
+		t.Errorf("loaded todos = %+v, want %+v", got, want)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f todoFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		t.Fatalf("migrated file is not a v%d envelope: %v\n%s", dataVersion, err, data)
+	}
+	if f.Version != dataVersion || !reflect.DeepEqual(f.Todos, want) {
+		t.Errorf("migrated file = version %d, %+v; want version %d, %+v", f.Version, f.Todos, dataVersion, want)
+	}
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Errorf("the v1 file was not kept as a backup: %v", err)
+	}
+}